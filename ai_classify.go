@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	duckdb "github.com/duckdb/duckdb-go-bindings"
+	"github.com/mlafeldt/quack-go/duckdbext"
+)
+
+type aiClassifyBind struct {
+	querySettings
+	labels []string
+}
+
+// aiClassifyConfidenceType is the result of ai_classify_confidence.
+func aiClassifyConfidenceType() duckdbext.LogicalType {
+	return duckdbext.Struct(
+		duckdbext.StructField{Name: "label", Type: duckdbext.Primitive(duckdb.TypeVarchar)},
+		duckdbext.StructField{Name: "confidence", Type: duckdbext.Primitive(duckdb.TypeDouble)},
+	)
+}
+
+// aiFilterConfidenceType is the result of ai_filter_confidence.
+func aiFilterConfidenceType() duckdbext.LogicalType {
+	return duckdbext.Struct(
+		duckdbext.StructField{Name: "value", Type: duckdbext.Primitive(duckdb.TypeBoolean)},
+		duckdbext.StructField{Name: "confidence", Type: duckdbext.Primitive(duckdb.TypeDouble)},
+	)
+}
+
+// classifyBind binds ai_classify(text, ['billing', 'bug', 'feature']), with the options STRUCT
+// at argument options or -1 for none. The labels must be constant, so they are checked once
+// and every answer is held to the same list.
+func classifyBind(options int, logprobs bool) duckdbext.ScalarBindFunc {
+	return func(info duckdbext.ScalarBindInfo) (any, error) {
+		v, err := info.ConstantArgument(1)
+		if err != nil {
+			return nil, fmt.Errorf("ai_classify: labels: %w", err)
+		}
+		labels, err := duckdbext.Strings(v)
+		if err != nil {
+			return nil, fmt.Errorf("ai_classify: labels: %w", err)
+		}
+		if err := checkLabels(labels); err != nil {
+			return nil, fmt.Errorf("ai_classify: %w", err)
+		}
+
+		q, err := typedBind(info, options, answerFormat{Logprobs: logprobs, Unfused: true})
+		if err != nil {
+			return nil, fmt.Errorf("ai_classify: %w", err)
+		}
+		return &aiClassifyBind{querySettings: q, labels: labels}, nil
+	}
+}
+
+// filterBind binds ai_filter(text, question) and its options overload like classifyBind.
+func filterBind(options int, logprobs bool) duckdbext.ScalarBindFunc {
+	return func(info duckdbext.ScalarBindInfo) (any, error) {
+		q, err := typedBind(info, options, answerFormat{Logprobs: logprobs, Unfused: true})
+		if err != nil {
+			return nil, fmt.Errorf("ai_filter: %w", err)
+		}
+		return q, nil
+	}
+}
+
+// typedBind is bindOptions for functions that write their own instruction, which leaves no
+// place for a library prompt.
+func typedBind(info duckdbext.ScalarBindInfo, options int, format answerFormat) (querySettings, error) {
+	q, err := bindOptions(info, options, format)
+	if err != nil {
+		return querySettings{}, err
+	}
+	if q.prompt != "" {
+		return querySettings{}, errors.New("prompt_ref is not supported, the function writes its own instruction")
+	}
+	return q, nil
+}
+
+// aiClassify implements ai_classify(text, labels) -> VARCHAR, one of labels. Answers are
+// matched ignoring case and punctuation; one that names no label, or several, is asked again
+// once and then becomes NULL.
+func aiClassify(info duckdb.FunctionInfo, input duckdb.DataChunk, output duckdb.Vector) {
+	classifyRows(info, input, output, false)
+}
+
+// aiClassifyConfidence implements ai_classify_confidence(text, labels) -> STRUCT(label,
+// confidence), where confidence is the probability of the answer if the provider reports it.
+func aiClassifyConfidence(info duckdb.FunctionInfo, input duckdb.DataChunk, output duckdb.Vector) {
+	classifyRows(info, input, output, true)
+}
+
+func classifyRows(info duckdb.FunctionInfo, input duckdb.DataChunk, output duckdb.Vector, withConfidence bool) {
+	b, ok := duckdbext.ScalarBindData(info).(*aiClassifyBind)
+	if !ok {
+		duckdbext.SetFunctionError(info, errors.New("ai_classify: bind data is missing"))
+		return
+	}
+	prompt := classifyPrompt(b.labels)
+
+	typedRows(info, input, output, typedCall{
+		name:     "ai_classify",
+		settings: b.querySettings,
+		prompt:   func(int) (string, bool) { return prompt, true },
+		parse: func(answer string) (any, error) {
+			return parseLabel(b.labels, answer)
+		},
+		set: func(v duckdbext.Vector, row int, val any) {
+			v.SetString(row, val.(string))
+		},
+		withConfidence: withConfidence,
+	})
+}
+
+// aiFilter implements ai_filter(text, question) -> BOOLEAN for WHERE clauses, e.g.
+// WHERE ai_filter(body, 'Is this spam?'). Answers must start with yes or no; others are asked
+// again once and then become NULL, which WHERE drops like false.
+func aiFilter(info duckdb.FunctionInfo, input duckdb.DataChunk, output duckdb.Vector) {
+	filterRows(info, input, output, false)
+}
+
+// aiFilterConfidence implements ai_filter_confidence(text, question) -> STRUCT(value,
+// confidence) like ai_classify_confidence.
+func aiFilterConfidence(info duckdb.FunctionInfo, input duckdb.DataChunk, output duckdb.Vector) {
+	filterRows(info, input, output, true)
+}
+
+func filterRows(info duckdb.FunctionInfo, input duckdb.DataChunk, output duckdb.Vector, withConfidence bool) {
+	settings := boundSettings(info)
+	question := duckdbext.ChunkVector(input, 1)
+
+	typedRows(info, input, output, typedCall{
+		name:     "ai_filter",
+		settings: settings,
+		prompt: func(row int) (string, bool) {
+			if !question.Valid(row) {
+				return "", false
+			}
+			return filterPrompt(question.String(row)), true
+		},
+		parse: func(answer string) (any, error) {
+			return parseYesNo(answer)
+		},
+		set: func(v duckdbext.Vector, row int, val any) {
+			duckdbext.Set(v, row, val.(bool))
+		},
+		withConfidence: withConfidence,
+	})
+}
+
+// typedCall describes a scalar function whose answers are parsed into a type, such as
+// ai_classify and ai_filter.
+type typedCall struct {
+	name     string
+	settings querySettings
+	prompt   func(row int) (string, bool) // false for NULL
+	parse    func(answer string) (any, error)
+	set      func(v duckdbext.Vector, row int, val any)
+
+	// withConfidence returns STRUCT(value, confidence DOUBLE) instead of the value.
+	withConfidence bool
+}
+
+// typedRows asks the rows of input with a text in column 0 and a prompt through tryParsed and
+// writes the accepted answers. Other rows are NULL; in strict mode a failed row fails the
+// query.
+func typedRows(info duckdb.FunctionInfo, input duckdb.DataChunk, output duckdb.Vector, c typedCall) {
+	numRows := int(duckdb.DataChunkGetSize(input))
+	if numRows == 0 {
+		return
+	}
+
+	textCol := duckdbext.ChunkVector(input, 0)
+
+	duckdb.VectorEnsureValidityWritable(output)
+	out := duckdbext.NewVector(output)
+	value, conf := out, duckdbext.Vector{}
+	if c.withConfidence {
+		value, conf = out.StructChild(0), out.StructChild(1)
+	}
+	setNull := func(row int) {
+		out.SetNull(row)
+		if c.withConfidence {
+			value.SetNull(row)
+			conf.SetNull(row)
+		}
+	}
+
+	rows := make([]int, 0, numRows)
+	texts := make([]string, 0, numRows)
+	prompts := make([]string, 0, numRows)
+
+	for row := 0; row < numRows; row++ {
+		prompt, ok := c.prompt(row)
+		if !textCol.Valid(row) || !ok {
+			setNull(row)
+			continue
+		}
+		rows = append(rows, row)
+		texts = append(texts, textCol.String(row))
+		prompts = append(prompts, prompt)
+	}
+
+	for k, a := range tryParsed(c.settings.dispatch, texts, prompts, c.parse) {
+		row := rows[k]
+
+		if a.Err != nil {
+			if c.settings.Strict {
+				duckdbext.SetFunctionError(info, fmt.Errorf("%s: %w", c.name, a.Err))
+				return
+			}
+			setNull(row)
+			continue
+		}
+
+		c.set(value, row, a.Value)
+		if c.withConfidence {
+			if p := confidence(a.Logprob); p != nil {
+				duckdbext.Set(conf, row, *p)
+			} else {
+				conf.SetNull(row)
+			}
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	duckdb "github.com/duckdb/duckdb-go-bindings"
+	"github.com/mlafeldt/quack-go/duckdbext"
+)
+
+type aiEmbedBind struct {
+	dispatcher *EmbedDispatcher
+	strict     bool
+}
+
+// embedBind binds ai_embed(text), with the options STRUCT at argument options or -1 for none.
+// The only option is the model: ai_embed(text, {'model': 'text-embedding-3-large'}).
+func embedBind(options int) duckdbext.ScalarBindFunc {
+	return func(info duckdbext.ScalarBindInfo) (any, error) {
+		provider, err := embedProviderFromEnv()
+		if err != nil {
+			return nil, fmt.Errorf("ai_embed: %w", err)
+		}
+		model := embedModelFromEnv(provider)
+
+		if options >= 0 {
+			v, err := info.ConstantArgument(options)
+			if err != nil {
+				return nil, fmt.Errorf("ai_embed: options: %w", err)
+			}
+			opts, ok := v.(map[string]any)
+			if v != nil && !ok {
+				return nil, errors.New("ai_embed: options must be a STRUCT such as {'model': '...'}")
+			}
+			for name, v := range opts {
+				if strings.ToLower(name) != "model" {
+					return nil, fmt.Errorf("ai_embed: unknown option %q, expected model", name)
+				}
+				if v == nil {
+					continue
+				}
+				s, ok := v.(string)
+				if !ok || s == "" {
+					return nil, fmt.Errorf("ai_embed: model: %v is not a model name", v)
+				}
+				model = s
+			}
+		}
+
+		conn := info.ConnectionID()
+		d, err := embedDispatcherFor(provider, model, secretKeyForProvider(conn, provider))
+		if err != nil {
+			return nil, fmt.Errorf("ai_embed: %w", err)
+		}
+		return &aiEmbedBind{dispatcher: d, strict: settingsFor(conn).Strict}, nil
+	}
+}
+
+// aiEmbed implements ai_embed(text) -> FLOAT[], the embedding of text. NULL and empty texts
+// are NULL, and so are the texts of a failed request unless the session is strict.
+func aiEmbed(info duckdb.FunctionInfo, input duckdb.DataChunk, output duckdb.Vector) {
+	numRows := int(duckdb.DataChunkGetSize(input))
+	if numRows == 0 {
+		return
+	}
+
+	b, ok := duckdbext.ScalarBindData(info).(*aiEmbedBind)
+	if !ok {
+		duckdbext.SetFunctionError(info, errors.New("ai_embed: bind data is missing"))
+		return
+	}
+
+	textCol := duckdbext.ChunkVector(input, 0)
+
+	duckdb.VectorEnsureValidityWritable(output)
+	out := duckdbext.NewVector(output)
+
+	texts := make([]string, numRows)
+	asked := make([]string, 0, numRows)
+	for row := 0; row < numRows; row++ {
+		if textCol.Valid(row) {
+			texts[row] = textCol.String(row)
+		}
+		if texts[row] != "" {
+			asked = append(asked, texts[row])
+		}
+	}
+
+	var (
+		vectors map[string][]float32
+		err     error
+	)
+	if len(asked) > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), 150*time.Second)
+		vectors, err = b.dispatcher.Submit(ctx, asked)
+		cancel()
+	}
+
+	total := 0
+	for _, t := range texts {
+		total += len(vectors[t])
+	}
+	offset := out.ReserveList(total)
+	child := out.ListChild()
+
+	for row, t := range texts {
+		v, ok := vectors[t]
+		if t == "" || !ok {
+			if t != "" && b.strict {
+				if err == nil {
+					err = errors.New("no embedding returned")
+				}
+				duckdbext.SetFunctionError(info, fmt.Errorf("ai_embed: %w", err))
+				return
+			}
+			out.SetNull(row)
+			continue
+		}
+		for i, x := range v {
+			duckdbext.Set(child, offset+i, x)
+		}
+		out.SetList(row, offset, len(v))
+		offset += len(v)
+	}
+}
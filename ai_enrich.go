@@ -0,0 +1,401 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	duckdb "github.com/duckdb/duckdb-go-bindings"
+	"github.com/mlafeldt/quack-go/duckdbext"
+)
+
+// enrichConn is a connection of our own, opened at load time, that reads the input of
+// ai_enrich and the few-shot example tables. It sees committed data only, not the temp tables
+// or open transaction of the caller.
+var (
+	enrichConn   duckdb.Connection
+	enrichConnMu sync.Mutex
+)
+
+// enrichScans are connections of our own, opened at load time, that stream the input of
+// ai_enrich and ai_extract. A streaming result holds its connection until the scan ends, so
+// each running scan takes one; scans that find none free read their input from enrichConn.
+var enrichScans chan duckdb.Connection
+
+// enrichScanConns is the number of enrichScans, and so of scans streaming at the same time.
+const enrichScanConns = 4
+
+type aiEnrichBind struct {
+	enrichInput
+	prompts []string
+
+	dispatch *dispatchSet // selected by the session settings of the caller
+}
+
+// enrichInput is the input of the table functions that add columns to a table or query,
+// ai_enrich and ai_extract: every input column is passed through and followed by the added
+// ones, computed from the text column.
+type enrichInput struct {
+	source  string
+	column  int
+	numCols int
+}
+
+type enrichState struct {
+	cols     []int // projected result columns
+	res      duckdb.Result
+	hasRes   bool
+	chunk    duckdb.DataChunk // input chunk the last output references
+	hasChunk bool
+	conn     duckdb.Connection // enrichScans connection of a streaming res
+	hasConn  bool
+}
+
+func (s *enrichState) Close() {
+	if s.hasChunk {
+		duckdb.DestroyDataChunk(&s.chunk)
+		s.hasChunk = false
+	}
+	if s.hasRes {
+		duckdb.DestroyResult(&s.res)
+		s.hasRes = false
+	}
+	if s.hasConn {
+		enrichScans <- s.conn
+		s.hasConn = false
+	}
+}
+
+// callOptions returns the named parameters model, max_tokens, temperature, system and
+// examples as options for bindSettings.
+func callOptions(info duckdbext.TableBindInfo) (map[string]any, error) {
+	opts := map[string]any{}
+	for _, name := range []string{"model", "max_tokens", "temperature", "system", "examples"} {
+		v, ok, err := info.NamedParam(name)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			opts[name] = v
+		}
+	}
+	return opts, nil
+}
+
+// callOptionParams declares the named parameters read by callOptions.
+func callOptionParams(params map[string]duckdbext.LogicalType) map[string]duckdbext.LogicalType {
+	varchar := duckdbext.Primitive(duckdb.TypeVarchar)
+
+	params["model"] = varchar
+	params["max_tokens"] = duckdbext.Primitive(duckdb.TypeInteger)
+	params["temperature"] = duckdbext.Primitive(duckdb.TypeDouble)
+	params["system"] = varchar
+	params["examples"] = varchar
+	return params
+}
+
+// aiEnrichFunction is ai_enrich(table_or_query, prompts := [...], column := 'text'): every row of
+// the input plus one VARCHAR column out<i> per prompt, run through the mode of the session.
+// model, max_tokens, temperature and system override the session's call parameters, and
+// examples names a table of few-shot examples.
+func aiEnrichFunction() duckdbext.TableFunction {
+	varchar := duckdbext.Primitive(duckdb.TypeVarchar)
+
+	return duckdbext.TableFunction{
+		Name:   "ai_enrich",
+		Params: []duckdbext.LogicalType{varchar},
+		NamedParams: callOptionParams(map[string]duckdbext.LogicalType{
+			"prompts": duckdbext.List(varchar),
+			"column":  varchar,
+		}),
+		Bind:       aiEnrichBindFunc,
+		Init:       aiEnrichInit,
+		Func:       aiEnrich,
+		Projection: true,
+	}
+}
+
+func aiEnrichBindFunc(info duckdbext.TableBindInfo) (any, error) {
+	source, err := info.StringParam(0)
+	if err != nil {
+		return nil, fmt.Errorf("ai_enrich: %w", err)
+	}
+
+	opts, err := callOptions(info)
+	if err != nil {
+		return nil, fmt.Errorf("ai_enrich: %w", err)
+	}
+	settings, err := bindSettings(info.ConnectionID(), opts, answerFormat{})
+	if err != nil {
+		return nil, fmt.Errorf("ai_enrich: %w", err)
+	}
+
+	b := &aiEnrichBind{dispatch: settings.dispatch}
+
+	prompts, ok, err := info.NamedStrings("prompts")
+	if err != nil {
+		return nil, fmt.Errorf("ai_enrich: %w", err)
+	}
+	if !ok {
+		return nil, errors.New("ai_enrich: prompts := [...] is required")
+	}
+	if len(prompts) == 0 {
+		return nil, errors.New("ai_enrich: prompts must not be empty")
+	}
+	b.prompts = prompts
+
+	column, err := info.NamedString("column", "text")
+	if err != nil {
+		return nil, fmt.Errorf("ai_enrich: %w", err)
+	}
+	if b.enrichInput, err = bindInput(info, source, column); err != nil {
+		return nil, fmt.Errorf("ai_enrich: %w", err)
+	}
+
+	for i := range b.prompts {
+		info.AddResultColumn("out"+strconv.Itoa(i), duckdbext.Primitive(duckdb.TypeVarchar))
+	}
+
+	return b, nil
+}
+
+func aiEnrichInit(info duckdbext.TableInitInfo, bindData any) (any, error) {
+	s, err := bindData.(*aiEnrichBind).init(info)
+	if err != nil {
+		return nil, fmt.Errorf("ai_enrich: %w", err)
+	}
+	return s, nil
+}
+
+func aiEnrich(scan duckdbext.TableScan, output duckdb.DataChunk) error {
+	b := scan.BindData.(*aiEnrichBind)
+	s := scan.State.(*enrichState)
+
+	n, added, err := s.next(b.enrichInput, len(b.prompts), output)
+	if err != nil {
+		return fmt.Errorf("ai_enrich: %w", err)
+	}
+	if n == 0 {
+		return nil
+	}
+
+	// only prompts whose column is selected are sent to the LLM
+	if len(added) > 0 {
+		prompts := make([]string, len(added))
+		for p, c := range added {
+			prompts[p] = b.prompts[c.index]
+		}
+
+		texts, textValid := s.texts(b.enrichInput, n)
+		results, valids := enrichTexts(b.dispatch, texts, textValid, prompts, runtime.GOMAXPROCS(0))
+
+		for p, c := range added {
+			out := duckdbext.ChunkVector(output, c.out)
+			for row := 0; row < n; row++ {
+				if !valids[p][row] {
+					out.SetNull(row)
+					continue
+				}
+				out.SetString(row, results[p][row])
+			}
+		}
+	}
+
+	duckdb.DataChunkSetSize(output, duckdb.IdxT(n))
+	return nil
+}
+
+// bindInput declares the columns of source, a table name or query, as the first result
+// columns; column names the VARCHAR text column.
+func bindInput(info duckdbext.TableBindInfo, source, column string) (enrichInput, error) {
+	sql, err := enrichSourceSQL(source)
+	if err != nil {
+		return enrichInput{}, err
+	}
+	in := enrichInput{source: sql, column: -1}
+
+	var res duckdb.Result
+	if err := enrichQuery("SELECT * FROM "+in.source+" LIMIT 0", &res); err != nil {
+		return enrichInput{}, err
+	}
+	defer duckdb.DestroyResult(&res)
+
+	in.numCols = int(duckdb.ColumnCount(&res))
+	for i := 0; i < in.numCols; i++ {
+		name := duckdb.ColumnName(&res, duckdb.IdxT(i))
+		lt := duckdb.ColumnLogicalType(&res, duckdb.IdxT(i))
+		if name == column {
+			if duckdb.GetTypeId(lt) != duckdb.TypeVarchar {
+				duckdb.DestroyLogicalType(&lt)
+				return enrichInput{}, fmt.Errorf("column %q must be VARCHAR", column)
+			}
+			in.column = i
+		}
+		info.AddResultColumnType(name, lt)
+		duckdb.DestroyLogicalType(&lt)
+	}
+	if in.column < 0 {
+		return enrichInput{}, fmt.Errorf("input has no column %q", column)
+	}
+	return in, nil
+}
+
+// init starts the scan of the input. The input is streamed, one chunk per call of next,
+// unless all enrichScans are taken; then it is read from enrichConn as a whole.
+func (in enrichInput) init(info duckdbext.TableInitInfo) (*enrichState, error) {
+	// one sequential scan; the dispatchers parallelize the LLM calls per chunk
+	info.SetMaxThreads(1)
+
+	s := &enrichState{cols: info.Columns()}
+	sql := "SELECT * FROM " + in.source
+
+	select {
+	case conn := <-enrichScans:
+		if err := duckdbext.QueryStreaming(conn, sql, &s.res); err != nil {
+			enrichScans <- conn
+			return nil, err
+		}
+		s.conn, s.hasConn = conn, true
+	default:
+		if err := enrichQuery(sql, &s.res); err != nil {
+			return nil, err
+		}
+	}
+	s.hasRes = true
+	return s, nil
+}
+
+// addedColumn is a selected column after the input columns: the index-th added column,
+// written to output column out.
+type addedColumn struct {
+	index int
+	out   int
+}
+
+// next fetches the next input chunk and passes its selected columns through to output. It
+// returns the number of rows, 0 at the end, and which of the numAdded columns are selected.
+// Errors come from computing a chunk of a streaming input.
+func (s *enrichState) next(in enrichInput, numAdded int, output duckdb.DataChunk) (int, []addedColumn, error) {
+	if s.hasChunk {
+		duckdb.DestroyDataChunk(&s.chunk)
+		s.hasChunk = false
+	}
+	if !s.hasRes {
+		duckdb.DataChunkSetSize(output, 0)
+		return 0, nil, nil
+	}
+
+	chunk := duckdb.FetchChunk(s.res)
+	if chunk.Ptr == nil {
+		var err error
+		if msg := duckdb.ResultError(&s.res); msg != "" {
+			err = errors.New(msg)
+		}
+		s.Close()
+		duckdb.DataChunkSetSize(output, 0)
+		return 0, nil, err
+	}
+	s.chunk = chunk
+	s.hasChunk = true
+
+	var added []addedColumn
+	for j, col := range s.cols {
+		switch {
+		case col < in.numCols:
+			duckdb.VectorReferenceVector(
+				duckdb.DataChunkGetVector(output, duckdb.IdxT(j)),
+				duckdb.DataChunkGetVector(chunk, duckdb.IdxT(col)),
+			)
+		case col < in.numCols+numAdded:
+			added = append(added, addedColumn{index: col - in.numCols, out: j})
+		}
+	}
+
+	return int(duckdb.DataChunkGetSize(chunk)), added, nil
+}
+
+// texts reads the text column of the current chunk of n rows; NULL texts are not valid.
+func (s *enrichState) texts(in enrichInput, n int) ([]string, []bool) {
+	textCol := duckdbext.ChunkVector(s.chunk, in.column)
+
+	texts := make([]string, n)
+	textValid := make([]bool, n)
+	for row := 0; row < n; row++ {
+		if !textCol.Valid(row) {
+			continue
+		}
+		texts[row] = textCol.String(row)
+		textValid[row] = true
+	}
+	return texts, textValid
+}
+
+var (
+	sourceQuery = regexp.MustCompile(`(?i)^\(*\s*(?:SELECT|FROM|WITH)\b`)
+	sourceName  = regexp.MustCompile(`^(?:[A-Za-z_][A-Za-z0-9_$]*|"(?:[^"]|"")+")(?:\.(?:[A-Za-z_][A-Za-z0-9_$]*|"(?:[^"]|"")+")){0,2}$`)
+)
+
+// enrichSourceSQL turns a table name or a query into something to select from. Sources that
+// start with SELECT, FROM or WITH are queries; anything else names a table, and is quoted as
+// one identifier unless it is a plain or quoted name, maybe qualified, already. Tables of the
+// temp schema are rejected, since enrichConn cannot see the caller's.
+func enrichSourceSQL(source string) (string, error) {
+	source = strings.TrimSpace(source)
+	if sourceQuery.MatchString(source) {
+		return "(" + source + ")", nil
+	}
+	if !sourceName.MatchString(source) {
+		return `"` + strings.ReplaceAll(source, `"`, `""`) + `"`, nil
+	}
+
+	parts := strings.Split(source, ".")
+	for _, part := range parts[:len(parts)-1] {
+		if strings.EqualFold(part, "temp") || strings.EqualFold(part, "temporary") {
+			return "", fmt.Errorf("%s is a TEMP table, which is read through a separate connection that cannot see it", source)
+		}
+	}
+	return source, nil
+}
+
+// openEnrichScans fills enrichScans with connections opened by connect.
+func openEnrichScans(connect func() (duckdb.Connection, error)) error {
+	enrichScans = make(chan duckdb.Connection, enrichScanConns)
+	for i := 0; i < enrichScanConns; i++ {
+		conn, err := connect()
+		if err != nil {
+			return err
+		}
+		enrichScans <- conn
+	}
+	return nil
+}
+
+// enrichQuery runs sql on enrichConn; on success the caller must destroy res.
+func enrichQuery(sql string, res *duckdb.Result) error {
+	if enrichConn.Ptr == nil {
+		return errors.New("no query connection")
+	}
+
+	enrichConnMu.Lock()
+	defer enrichConnMu.Unlock()
+
+	if duckdb.Query(enrichConn, sql, res) == duckdb.StateError {
+		err := errors.New(duckdb.ResultError(res))
+		duckdb.DestroyResult(res)
+		return notVisible(err)
+	}
+	return nil
+}
+
+// notVisible explains a catalog error of enrichConn: tables the caller has not committed are
+// not an empty input but missing.
+func notVisible(err error) error {
+	if !strings.HasPrefix(err.Error(), "Catalog Error") {
+		return err
+	}
+	return fmt.Errorf("%w (the input is read through a separate connection, so TEMP tables and tables of an uncommitted transaction are not visible)", err)
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	duckdb "github.com/duckdb/duckdb-go-bindings"
+	"github.com/mlafeldt/quack-go/duckdbext"
+)
+
+// defaultExtractPrompt is the instruction of ai_extract without prompt := '...'.
+const defaultExtractPrompt = "Extract the fields of the answer structure from the text. Use null for anything the text does not say."
+
+type aiExtractBind struct {
+	enrichInput
+	typ    extractType
+	prompt string
+	strict bool
+
+	dispatch *dispatchSet // selected by the session settings of the caller
+}
+
+// aiExtractFunction is ai_extract(table_or_query, 'name VARCHAR, age INTEGER, tags VARCHAR[]',
+// column := 'text', prompt := '...'): every row of the input plus a column extracted, a STRUCT
+// of the spec's fields filled from an answer constrained to its JSON schema. Scalar functions
+// cannot pick their return type when bound, so like ai_enrich it is a table function.
+func aiExtractFunction() duckdbext.TableFunction {
+	varchar := duckdbext.Primitive(duckdb.TypeVarchar)
+
+	return duckdbext.TableFunction{
+		Name:   "ai_extract",
+		Params: []duckdbext.LogicalType{varchar, varchar},
+		NamedParams: callOptionParams(map[string]duckdbext.LogicalType{
+			"column": varchar,
+			"prompt": varchar,
+		}),
+		Bind:       aiExtractBindFunc,
+		Init:       aiExtractInit,
+		Func:       aiExtract,
+		Projection: true,
+	}
+}
+
+func aiExtractBindFunc(info duckdbext.TableBindInfo) (any, error) {
+	source, err := info.StringParam(0)
+	if err != nil {
+		return nil, fmt.Errorf("ai_extract: %w", err)
+	}
+	spec, err := info.StringParam(1)
+	if err != nil {
+		return nil, fmt.Errorf("ai_extract: %w", err)
+	}
+
+	b := &aiExtractBind{}
+	if b.typ, err = parseExtractSpec(spec); err != nil {
+		return nil, fmt.Errorf("ai_extract: spec: %w", err)
+	}
+
+	opts, err := callOptions(info)
+	if err != nil {
+		return nil, fmt.Errorf("ai_extract: %w", err)
+	}
+	settings, err := bindSettings(info.ConnectionID(), opts, answerFormat{Schema: b.typ.schemaJSON()})
+	if err != nil {
+		return nil, fmt.Errorf("ai_extract: %w", err)
+	}
+	b.dispatch, b.strict = settings.dispatch, settings.Strict
+
+	if b.prompt, err = info.NamedString("prompt", defaultExtractPrompt); err != nil {
+		return nil, fmt.Errorf("ai_extract: %w", err)
+	}
+	if b.prompt == "" {
+		return nil, errors.New("ai_extract: prompt must not be empty")
+	}
+
+	column, err := info.NamedString("column", "text")
+	if err != nil {
+		return nil, fmt.Errorf("ai_extract: %w", err)
+	}
+	if b.enrichInput, err = bindInput(info, source, column); err != nil {
+		return nil, fmt.Errorf("ai_extract: %w", err)
+	}
+
+	info.AddResultColumn("extracted", b.typ.logicalType())
+
+	return b, nil
+}
+
+func aiExtractInit(info duckdbext.TableInitInfo, bindData any) (any, error) {
+	s, err := bindData.(*aiExtractBind).init(info)
+	if err != nil {
+		return nil, fmt.Errorf("ai_extract: %w", err)
+	}
+	return s, nil
+}
+
+func aiExtract(scan duckdbext.TableScan, output duckdb.DataChunk) error {
+	b := scan.BindData.(*aiExtractBind)
+	s := scan.State.(*enrichState)
+
+	n, added, err := s.next(b.enrichInput, 1, output)
+	if err != nil {
+		return fmt.Errorf("ai_extract: %w", err)
+	}
+	if n == 0 {
+		return nil
+	}
+
+	// nothing is extracted unless the column is selected
+	if len(added) > 0 {
+		out := duckdbext.ChunkVector(output, added[0].out)
+
+		texts, textValid := s.texts(b.enrichInput, n)
+		var (
+			rows    []int
+			asked   []string
+			prompts []string
+		)
+		for row := 0; row < n; row++ {
+			if !textValid[row] {
+				setExtractedNull(out, b.typ, row)
+				continue
+			}
+			rows = append(rows, row)
+			asked = append(asked, texts[row])
+			prompts = append(prompts, b.prompt)
+		}
+
+		answers := tryParsed(b.dispatch, asked, prompts, func(answer string) (any, error) {
+			return parseExtractReply(b.typ, answer)
+		})
+
+		for k, row := range rows {
+			if a := answers[k]; a.Err != nil {
+				if b.strict {
+					return fmt.Errorf("ai_extract: %w", a.Err)
+				}
+				setExtractedNull(out, b.typ, row)
+				continue
+			}
+			writeExtracted(out, b.typ, row, answers[k].Value)
+		}
+	}
+
+	duckdb.DataChunkSetSize(output, duckdb.IdxT(n))
+	return nil
+}
+
+// logicalType is the DuckDB type of t.
+func (t extractType) logicalType() duckdbext.LogicalType {
+	switch t.name {
+	case "LIST":
+		return duckdbext.List(t.elem.logicalType())
+	case "STRUCT":
+		fields := make([]duckdbext.StructField, len(t.fields))
+		for i, f := range t.fields {
+			fields[i] = duckdbext.StructField{Name: f.name, Type: f.typ.logicalType()}
+		}
+		return duckdbext.Struct(fields...)
+	}
+	return duckdbext.Primitive(map[string]duckdb.Type{
+		"VARCHAR":  duckdb.TypeVarchar,
+		"BOOLEAN":  duckdb.TypeBoolean,
+		"TINYINT":  duckdb.TypeTinyInt,
+		"SMALLINT": duckdb.TypeSmallInt,
+		"INTEGER":  duckdb.TypeInteger,
+		"BIGINT":   duckdb.TypeBigInt,
+		"FLOAT":    duckdb.TypeFloat,
+		"DOUBLE":   duckdb.TypeDouble,
+	}[t.name])
+}
+
+// writeExtracted writes val, a value of t as returned by parseExtractReply, to row of v.
+func writeExtracted(v duckdbext.Vector, t extractType, row int, val any) {
+	if val == nil {
+		setExtractedNull(v, t, row)
+		return
+	}
+
+	switch t.name {
+	case "VARCHAR":
+		v.SetString(row, val.(string))
+	case "BOOLEAN":
+		duckdbext.Set(v, row, val.(bool))
+	case "TINYINT":
+		duckdbext.Set(v, row, int8(val.(int64)))
+	case "SMALLINT":
+		duckdbext.Set(v, row, int16(val.(int64)))
+	case "INTEGER":
+		duckdbext.Set(v, row, int32(val.(int64)))
+	case "BIGINT":
+		duckdbext.Set(v, row, val.(int64))
+	case "FLOAT":
+		duckdbext.Set(v, row, float32(val.(float64)))
+	case "DOUBLE":
+		duckdbext.Set(v, row, val.(float64))
+
+	case "LIST":
+		list := val.([]any)
+		offset := v.ReserveList(len(list))
+		child := v.ListChild()
+		for i, e := range list {
+			writeExtracted(child, *t.elem, offset+i, e)
+		}
+		v.SetList(row, offset, len(list))
+
+	case "STRUCT":
+		fields := val.([]any)
+		for i, f := range t.fields {
+			writeExtracted(v.StructChild(i), f.typ, row, fields[i])
+		}
+	}
+}
+
+// setExtractedNull sets row of v to NULL, including the fields of a STRUCT.
+func setExtractedNull(v duckdbext.Vector, t extractType, row int) {
+	v.SetNull(row)
+	if t.name == "STRUCT" {
+		for i, f := range t.fields {
+			setExtractedNull(v.StructChild(i), f.typ, row)
+		}
+	}
+}
@@ -2,49 +2,57 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"runtime"
 	"sync"
 	"time"
 
 	duckdb "github.com/duckdb/duckdb-go-bindings"
+	"github.com/mlafeldt/quack-go/duckdbext"
 )
 
 // Modes:
 // - fusedDispatcher fuses multiple prompts per same text into one request, returns answers separated by ";"
-// - singleClient one request per row+prompt
+// - singleProvider one request per row+prompt
 // - dispatcher batch across rows/prompts (dedup by text+prompt within chunk), unstable due to high API response times... :/
 func aiLLM(info duckdb.FunctionInfo, input duckdb.DataChunk, output duckdb.Vector) {
-	numRows := duckdb.DataChunkGetSize(input)
+	numRows := int(duckdb.DataChunkGetSize(input))
 	if numRows == 0 {
 		return
 	}
 
-	colVec := duckdb.DataChunkGetVector(input, 0)
-	promptVec := duckdb.DataChunkGetVector(input, 1)
+	settings := boundSettings(info)
+	ds := settings.dispatch
 
-	colData := (*[1 << 28]duckdb.StringT)(duckdb.VectorGetData(colVec))
-	colValidity := duckdb.VectorGetValidity(colVec)
-
-	promptData := (*[1 << 28]duckdb.StringT)(duckdb.VectorGetData(promptVec))
-	promptValidity := duckdb.VectorGetValidity(promptVec)
+	textCol := duckdbext.ChunkVector(input, 0)
+	promptAt := settings.promptColumn(input, 1)
 
 	duckdb.VectorEnsureValidityWritable(output)
-	outValidity := duckdb.VectorGetValidity(output)
+	out := duckdbext.NewVector(output)
+
+	// in strict mode the first failure fails the query instead of becoming NULL
+	var errs rowErrors
+	defer func() {
+		if settings.Strict && errs.err != nil {
+			duckdbext.SetFunctionError(info, errs.err)
+		}
+	}()
 
-	if fusedDispatcher == nil && singleClient == nil && dispatcher == nil {
-		for row := duckdb.IdxT(0); row < numRows; row++ {
-			duckdb.ValiditySetRowInvalid(outValidity, row)
+	if ds.fusedDispatcher == nil && ds.singleProvider == nil && ds.dispatcher == nil {
+		errs.add(errors.New("ai_llm: no LLM provider configured"))
+		for row := 0; row < numRows; row++ {
+			out.SetNull(row)
 		}
 		return
 	}
 
-	if fusedDispatcher != nil {
+	if ds.fusedDispatcher != nil {
 		workers := runtime.GOMAXPROCS(0)
 		var wg sync.WaitGroup
 
 		type job struct {
-			row    duckdb.IdxT
+			row    int
 			text   string
 			prompt string
 		}
@@ -55,26 +63,31 @@ func aiLLM(info duckdb.FunctionInfo, input duckdb.DataChunk, output duckdb.Vecto
 			go func() {
 				defer wg.Done()
 				for j := range jobCh {
-					ans, err := fusedDispatcher.GetResult(j.text, j.prompt)
-					if err != nil || ans == "" {
+					ans, err := ds.fusedDispatcher.GetResult(j.text, j.prompt)
+					if err == nil && ans == "" {
+						err = emptyAnswer(j.row)
+					}
+					if err != nil {
 						fmt.Println("Invalid")
-						duckdb.ValiditySetRowInvalid(outValidity, j.row)
+						errs.add(err)
+						out.SetNull(j.row)
 						continue
 					}
-					duckdb.VectorAssignStringElement(output, j.row, ans)
+					out.SetString(j.row, ans)
 				}
 			}()
 		}
 
-		for row := duckdb.IdxT(0); row < numRows; row++ {
-			if !duckdb.ValidityRowIsValid(colValidity, row) || !duckdb.ValidityRowIsValid(promptValidity, row) {
-				duckdb.ValiditySetRowInvalid(outValidity, row)
+		for row := 0; row < numRows; row++ {
+			prompt, ok := promptAt(row)
+			if !textCol.Valid(row) || !ok {
+				out.SetNull(row)
 				continue
 			}
 			jobCh <- job{
 				row:    row,
-				text:   duckdb.StringTData(&colData[row]),
-				prompt: duckdb.StringTData(&promptData[row]),
+				text:   textCol.String(row),
+				prompt: prompt,
 			}
 		}
 
@@ -83,12 +96,12 @@ func aiLLM(info duckdb.FunctionInfo, input duckdb.DataChunk, output duckdb.Vecto
 		return
 	}
 
-	if singleClient != nil {
+	if ds.singleProvider != nil {
 		workers := runtime.GOMAXPROCS(0)
 		var wg sync.WaitGroup
 
 		type job struct {
-			row    duckdb.IdxT
+			row    int
 			text   string
 			prompt string
 		}
@@ -102,25 +115,30 @@ func aiLLM(info duckdb.FunctionInfo, input duckdb.DataChunk, output duckdb.Vecto
 			go func() {
 				defer wg.Done()
 				for j := range jobCh {
-					ans, err := singleClient.Run(ctx, j.text, j.prompt)
-					if err != nil || ans == "" {
-						duckdb.ValiditySetRowInvalid(outValidity, j.row)
+					c, err := ds.singleProvider.Complete(ctx, CompletionRequest{Text: j.text, Prompt: j.prompt})
+					if err == nil && c.Text == "" {
+						err = emptyAnswer(j.row)
+					}
+					if err != nil {
+						errs.add(err)
+						out.SetNull(j.row)
 						continue
 					}
-					duckdb.VectorAssignStringElement(output, j.row, ans)
+					out.SetString(j.row, c.Text)
 				}
 			}()
 		}
 
-		for row := duckdb.IdxT(0); row < numRows; row++ {
-			if !duckdb.ValidityRowIsValid(colValidity, row) || !duckdb.ValidityRowIsValid(promptValidity, row) {
-				duckdb.ValiditySetRowInvalid(outValidity, row)
+		for row := 0; row < numRows; row++ {
+			prompt, ok := promptAt(row)
+			if !textCol.Valid(row) || !ok {
+				out.SetNull(row)
 				continue
 			}
 			jobCh <- job{
 				row:    row,
-				text:   duckdb.StringTData(&colData[row]),
-				prompt: duckdb.StringTData(&promptData[row]),
+				text:   textCol.String(row),
+				prompt: prompt,
 			}
 		}
 
@@ -130,27 +148,27 @@ func aiLLM(info duckdb.FunctionInfo, input duckdb.DataChunk, output duckdb.Vecto
 	}
 
 	type rowRef struct {
-		row duckdb.IdxT
+		row int
 		cid string
 	}
 
-	refs := make([]rowRef, 0, int(numRows))
+	refs := make([]rowRef, 0, numRows)
 
 	// custom_id -> (text,prompt)
 	type tp struct {
 		text   string
 		prompt string
 	}
-	uniq := make(map[string]tp, int(numRows))
+	uniq := make(map[string]tp, numRows)
 
-	for row := duckdb.IdxT(0); row < numRows; row++ {
-		if !duckdb.ValidityRowIsValid(colValidity, row) || !duckdb.ValidityRowIsValid(promptValidity, row) {
-			duckdb.ValiditySetRowInvalid(outValidity, row)
+	for row := 0; row < numRows; row++ {
+		prompt, ok := promptAt(row)
+		if !textCol.Valid(row) || !ok {
+			out.SetNull(row)
 			continue
 		}
 
-		text := duckdb.StringTData(&colData[row])
-		prompt := duckdb.StringTData(&promptData[row])
+		text := textCol.String(row)
 
 		cid := customID(text, prompt)
 
@@ -175,20 +193,46 @@ func aiLLM(info duckdb.FunctionInfo, input duckdb.DataChunk, output duckdb.Vecto
 	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
 	defer cancel()
 
-	resMap, err := dispatcher.Submit(ctx, jobs)
+	resMap, err := ds.dispatcher.Submit(ctx, jobs)
 	if err != nil {
+		errs.add(err)
 		for _, r := range refs {
-			duckdb.ValiditySetRowInvalid(outValidity, r.row)
+			out.SetNull(r.row)
 		}
 		return
 	}
 
 	for _, r := range refs {
 		ans, ok := resMap[r.cid]
-		if !ok || ans == "" {
-			duckdb.ValiditySetRowInvalid(outValidity, r.row)
+		if !ok || ans.Text == "" {
+			// batches report failed items as empty answers
+			errs.add(fmt.Errorf("ai_llm: batch item of row %d failed or returned no answer", r.row))
+			out.SetNull(r.row)
 			continue
 		}
-		duckdb.VectorAssignStringElement(output, r.row, ans)
+		out.SetString(r.row, ans.Text)
+	}
+}
+
+// rowErrors keeps the first failure of a chunk. Callers report a missing or empty answer as
+// an error too, see emptyAnswer, so strict mode fails whenever a row gets no answer.
+type rowErrors struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (r *rowErrors) add(err error) {
+	if err == nil {
+		return
 	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// emptyAnswer is the error of a row whose call succeeded without an answer.
+func emptyAnswer(row int) error {
+	return fmt.Errorf("ai_llm: empty answer for row %d", row)
 }
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+
+	duckdb "github.com/duckdb/duckdb-go-bindings"
+	"github.com/mlafeldt/quack-go/duckdbext"
+)
+
+// aiLLMMulti implements ai_llm_multi(text, prompts VARCHAR[]) -> MAP(VARCHAR, VARCHAR).
+// All prompts of a row go out as one fused request, so fusing does not depend on timing.
+func aiLLMMulti(info duckdb.FunctionInfo, input duckdb.DataChunk, output duckdb.Vector) {
+	numRows := int(duckdb.DataChunkGetSize(input))
+	if numRows == 0 {
+		return
+	}
+
+	textCol := duckdbext.ChunkVector(input, 0)
+	listCol := duckdbext.ChunkVector(input, 1)
+	promptCol := listCol.ListChild()
+
+	duckdb.VectorEnsureValidityWritable(output)
+	out := duckdbext.NewVector(output)
+
+	settings := boundSettings(info)
+	var errs rowErrors
+	defer func() {
+		if settings.Strict && errs.err != nil {
+			duckdbext.SetFunctionError(info, errs.err)
+		}
+	}()
+
+	if settings.dispatch.multiDispatcher == nil {
+		errs.add(errors.New("ai_llm_multi: no LLM provider configured"))
+		for row := 0; row < numRows; row++ {
+			out.SetNull(row)
+		}
+		return
+	}
+
+	type job struct {
+		row     int
+		text    string
+		prompts []string
+	}
+
+	// answers[row] is ordered like the row's prompts; nil means NULL
+	answers := make([][]string, numRows)
+	prompts := make([][]string, numRows)
+
+	workers := runtime.GOMAXPROCS(0)
+	var wg sync.WaitGroup
+	jobCh := make(chan job, numRows)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobCh {
+				res, err := settings.dispatch.multiDispatcher.GetResults(j.text, j.prompts)
+				if err != nil {
+					errs.add(err)
+					continue
+				}
+				out := make([]string, len(j.prompts))
+				for k, p := range j.prompts {
+					ans, ok := res[p]
+					if !ok || ans == "" {
+						errs.add(fmt.Errorf("ai_llm_multi: no answer to %q for row %d", p, j.row))
+					}
+					out[k] = ans
+				}
+				answers[j.row] = out
+			}
+		}()
+	}
+
+	for row := 0; row < numRows; row++ {
+		if !textCol.Valid(row) || !listCol.Valid(row) {
+			continue
+		}
+
+		// a repeated prompt is asked once: MAP keys must be unique
+		offset, length := listCol.List(row)
+		ps := make([]string, 0, length)
+		seen := make(map[string]bool, length)
+		for k := offset; k < offset+length; k++ {
+			if !promptCol.Valid(k) {
+				continue
+			}
+			p := promptCol.String(k)
+			if seen[p] {
+				continue
+			}
+			seen[p] = true
+			ps = append(ps, p)
+		}
+		prompts[row] = ps
+
+		if len(ps) == 0 {
+			answers[row] = []string{}
+			continue
+		}
+		jobCh <- job{row: row, text: textCol.String(row), prompts: ps}
+	}
+
+	close(jobCh)
+	wg.Wait()
+
+	// MAP is a LIST of STRUCT(key, value)
+	total := 0
+	for _, a := range answers {
+		total += len(a)
+	}
+	offset := out.ReserveList(total)
+
+	entries := out.ListChild()
+	keys := entries.StructChild(0)
+	values := entries.StructChild(1)
+
+	for row := 0; row < numRows; row++ {
+		a := answers[row]
+		if a == nil {
+			out.SetNull(row)
+			continue
+		}
+		for k, ans := range a {
+			keys.SetString(offset+k, prompts[row][k])
+			values.SetString(offset+k, ans)
+		}
+		out.SetList(row, offset, len(a))
+		offset += len(a)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	duckdb "github.com/duckdb/duckdb-go-bindings"
+	"github.com/mlafeldt/quack-go/duckdbext"
+)
+
+// aiLLMTryType is the result of ai_llm_try.
+func aiLLMTryType() duckdbext.LogicalType {
+	varchar := duckdbext.Primitive(duckdb.TypeVarchar)
+
+	return duckdbext.Struct(
+		duckdbext.StructField{Name: "value", Type: varchar},
+		duckdbext.StructField{Name: "error", Type: varchar},
+		duckdbext.StructField{Name: "error_kind", Type: varchar},
+		duckdbext.StructField{Name: "attempts", Type: duckdbext.Primitive(duckdb.TypeInteger)},
+		duckdbext.StructField{Name: "cached", Type: duckdbext.Primitive(duckdb.TypeBoolean)},
+	)
+}
+
+// aiLLMTry implements ai_llm_try(text, prompt) -> STRUCT(value, error, error_kind, attempts,
+// cached). Unlike ai_llm a failed row keeps its error, so it can be found and re-run. The
+// prompt may also come from the library, ai_llm_try(text, {'prompt_ref': 'sentiment@2'}).
+func aiLLMTry(info duckdb.FunctionInfo, input duckdb.DataChunk, output duckdb.Vector) {
+	numRows := int(duckdb.DataChunkGetSize(input))
+	if numRows == 0 {
+		return
+	}
+
+	settings := boundSettings(info)
+	textCol := duckdbext.ChunkVector(input, 0)
+	promptAt := settings.promptColumn(input, 1)
+
+	duckdb.VectorEnsureValidityWritable(output)
+	out := duckdbext.NewVector(output)
+	fields := make([]duckdbext.Vector, 5)
+	for i := range fields {
+		fields[i] = out.StructChild(i)
+	}
+	value, errMsg, errKind, attempts, cached := fields[0], fields[1], fields[2], fields[3], fields[4]
+
+	rows := make([]int, 0, numRows)
+	texts := make([]string, 0, numRows)
+	prompts := make([]string, 0, numRows)
+
+	for row := 0; row < numRows; row++ {
+		prompt, ok := promptAt(row)
+		if !textCol.Valid(row) || !ok {
+			out.SetNull(row)
+			for _, f := range fields {
+				f.SetNull(row)
+			}
+			continue
+		}
+		rows = append(rows, row)
+		texts = append(texts, textCol.String(row))
+		prompts = append(prompts, prompt)
+	}
+
+	for i, o := range tryLLM(settings.dispatch, texts, prompts) {
+		row := rows[i]
+
+		if o.Err != nil {
+			value.SetNull(row)
+			errMsg.SetString(row, o.Err.Error())
+			errKind.SetString(row, errorKind(o.Err))
+		} else {
+			value.SetString(row, o.Value)
+			errMsg.SetNull(row)
+			errKind.SetNull(row)
+		}
+		duckdbext.Set(attempts, row, int32(o.Attempts))
+		duckdbext.Set(cached, row, o.Cached)
+	}
+}
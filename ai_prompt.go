@@ -0,0 +1,295 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	duckdb "github.com/duckdb/duckdb-go-bindings"
+	"github.com/mlafeldt/quack-go/duckdbext"
+)
+
+type aiPromptBind struct {
+	fields   []duckdbext.StructField
+	index    map[string]int  // lower-case field name -> field
+	tmpl     *promptTemplate // parsed at bind time when the template is constant
+	maxChars int             // QUACK_PROMPT_MAX_CHARS, 0 is unlimited
+}
+
+// aiPromptFunction is ai_prompt(template, {'name': column, ...}): the template rendered per
+// row, e.g. ai_prompt('Classify {title} given {body:2000}', {'title': title, 'body': body}).
+// NULL fields render as empty text.
+func aiPromptFunction() duckdbext.ScalarFunction {
+	varchar := duckdbext.Primitive(duckdb.TypeVarchar)
+
+	return duckdbext.ScalarFunction{
+		Name:   "ai_prompt",
+		Params: []duckdbext.LogicalType{varchar, duckdbext.Any()},
+		Return: varchar,
+		Bind:   aiPromptBindFunc,
+		Func:   aiPrompt,
+	}
+}
+
+func aiPromptBindFunc(info duckdbext.ScalarBindInfo) (any, error) {
+	t := info.ArgumentType(1)
+	if t.ID() != duckdb.TypeStruct {
+		return nil, errors.New("ai_prompt: the second argument must be a STRUCT such as {'title': title, 'body': body}")
+	}
+
+	b := &aiPromptBind{fields: t.Fields(), index: map[string]int{}}
+	names := make([]string, len(b.fields))
+	for i, f := range b.fields {
+		if !promptFieldType(f.Type.ID()) {
+			return nil, fmt.Errorf("ai_prompt: field %q must be text, a number or a boolean; cast it to VARCHAR", f.Name)
+		}
+		names[i] = f.Name
+		b.index[strings.ToLower(f.Name)] = i
+	}
+
+	// a constant template is checked once, so mistakes fail before any row is read
+	if v, err := info.ConstantArgument(0); err == nil {
+		if s, ok := v.(string); ok {
+			tmpl, err := parsePromptTemplate(s)
+			if err != nil {
+				return nil, fmt.Errorf("ai_prompt: %w", err)
+			}
+			if err := tmpl.check(names); err != nil {
+				return nil, fmt.Errorf("ai_prompt: %w", err)
+			}
+			b.tmpl = tmpl
+		}
+	}
+
+	if n, ok := envInt("QUACK_PROMPT_MAX_CHARS"); ok && n > 0 {
+		b.maxChars = n
+	}
+
+	return b, nil
+}
+
+func aiPrompt(info duckdb.FunctionInfo, input duckdb.DataChunk, output duckdb.Vector) {
+	numRows := int(duckdb.DataChunkGetSize(input))
+	if numRows == 0 {
+		return
+	}
+
+	b, ok := duckdbext.ScalarBindData(info).(*aiPromptBind)
+	if !ok {
+		duckdbext.SetFunctionError(info, errors.New("ai_prompt: bind data is missing"))
+		return
+	}
+
+	tmplCol := duckdbext.ChunkVector(input, 0)
+	valCol := duckdbext.ChunkVector(input, 1)
+
+	children := make([]duckdbext.Vector, len(b.fields))
+	names := make([]string, len(b.fields))
+	for i, f := range b.fields {
+		children[i] = valCol.StructChild(i)
+		names[i] = f.Name
+	}
+
+	duckdb.VectorEnsureValidityWritable(output)
+	out := duckdbext.NewVector(output)
+
+	// templates that are not constant are parsed once per distinct text
+	parsed := map[string]*promptTemplate{}
+
+	for row := 0; row < numRows; row++ {
+		if !tmplCol.Valid(row) || !valCol.Valid(row) {
+			out.SetNull(row)
+			continue
+		}
+
+		tmpl := b.tmpl
+		if tmpl == nil {
+			s := tmplCol.String(row)
+			if tmpl = parsed[s]; tmpl == nil {
+				var err error
+				if tmpl, err = parsePromptTemplate(s); err == nil {
+					err = tmpl.check(names)
+				}
+				if err != nil {
+					duckdbext.SetFunctionError(info, fmt.Errorf("ai_prompt: %w", err))
+					return
+				}
+				parsed[s] = tmpl
+			}
+		}
+
+		text, err := tmpl.render(func(name string) string {
+			i := b.index[strings.ToLower(name)]
+			if !children[i].Valid(row) {
+				return ""
+			}
+			return promptValue(children[i], b.fields[i].Type.ID(), row)
+		}, b.maxChars)
+		if err != nil {
+			duckdbext.SetFunctionError(info, fmt.Errorf("ai_prompt: row %d: %w", row, err))
+			return
+		}
+
+		out.SetString(row, text)
+	}
+}
+
+func promptFieldType(id duckdb.Type) bool {
+	switch id {
+	case duckdb.TypeVarchar, duckdb.TypeBoolean,
+		duckdb.TypeTinyInt, duckdb.TypeSmallInt, duckdb.TypeInteger, duckdb.TypeBigInt,
+		duckdb.TypeUTinyInt, duckdb.TypeUSmallInt, duckdb.TypeUInteger, duckdb.TypeUBigInt,
+		duckdb.TypeFloat, duckdb.TypeDouble:
+		return true
+	}
+	return false
+}
+
+// promptValue formats one field value of a type accepted by promptFieldType.
+func promptValue(v duckdbext.Vector, id duckdb.Type, row int) string {
+	switch id {
+	case duckdb.TypeBoolean:
+		return strconv.FormatBool(duckdbext.Get[bool](v, row))
+	case duckdb.TypeTinyInt:
+		return strconv.FormatInt(int64(duckdbext.Get[int8](v, row)), 10)
+	case duckdb.TypeSmallInt:
+		return strconv.FormatInt(int64(duckdbext.Get[int16](v, row)), 10)
+	case duckdb.TypeInteger:
+		return strconv.FormatInt(int64(duckdbext.Get[int32](v, row)), 10)
+	case duckdb.TypeBigInt:
+		return strconv.FormatInt(duckdbext.Get[int64](v, row), 10)
+	case duckdb.TypeUTinyInt:
+		return strconv.FormatUint(uint64(duckdbext.Get[uint8](v, row)), 10)
+	case duckdb.TypeUSmallInt:
+		return strconv.FormatUint(uint64(duckdbext.Get[uint16](v, row)), 10)
+	case duckdb.TypeUInteger:
+		return strconv.FormatUint(uint64(duckdbext.Get[uint32](v, row)), 10)
+	case duckdb.TypeUBigInt:
+		return strconv.FormatUint(duckdbext.Get[uint64](v, row), 10)
+	case duckdb.TypeFloat:
+		return strconv.FormatFloat(float64(duckdbext.Get[float32](v, row)), 'g', -1, 32)
+	case duckdb.TypeDouble:
+		return strconv.FormatFloat(duckdbext.Get[float64](v, row), 'g', -1, 64)
+	default:
+		return v.String(row)
+	}
+}
+
+// aiPromptRegisterFunction is CALL ai_prompt_register(name, prompt, version := N): adds a
+// version of a library prompt, by default the one after the latest, and returns it as (name,
+// version, ref). Queries use it with ai_llm(text, {'prompt_ref': 'name@N'}).
+func aiPromptRegisterFunction() duckdbext.TableFunction {
+	varchar := duckdbext.Primitive(duckdb.TypeVarchar)
+
+	return duckdbext.TableFunction{
+		Name:   "ai_prompt_register",
+		Params: []duckdbext.LogicalType{varchar, varchar},
+		NamedParams: map[string]duckdbext.LogicalType{
+			"version": duckdbext.Primitive(duckdb.TypeInteger),
+		},
+		Bind: func(info duckdbext.TableBindInfo) (any, error) {
+			name, err := info.StringParam(0)
+			if err != nil {
+				return nil, err
+			}
+			prompt, err := info.StringParam(1)
+			if err != nil {
+				return nil, err
+			}
+
+			version := 0
+			if v, ok, err := info.NamedParam("version"); err != nil {
+				return nil, err
+			} else if ok && v != nil {
+				n, ok := v.(int64)
+				if !ok || n < 1 {
+					return nil, fmt.Errorf("ai_prompt_register: version %v is not positive", v)
+				}
+				version = int(n)
+			}
+
+			lib, err := promptLibraryFromEnv()
+			if err != nil {
+				return nil, fmt.Errorf("ai_prompt_register: %w", err)
+			}
+			p, err := lib.register(name, prompt, version)
+			if err != nil {
+				return nil, fmt.Errorf("ai_prompt_register: %w", err)
+			}
+
+			rows := &promptRows{prompts: []libraryPrompt{p}}
+			rows.addColumns(info)
+			info.SetCardinality(1, true)
+			return rows, nil
+		},
+		Init: varcharRowsInit,
+		Func: promptRowsFunc,
+	}
+}
+
+// aiPromptsFunction is ai_prompts(): every version of every library prompt as (name, version,
+// ref, prompt, registered_at).
+func aiPromptsFunction() duckdbext.TableFunction {
+	return duckdbext.TableFunction{
+		Name: "ai_prompts",
+		Bind: func(info duckdbext.TableBindInfo) (any, error) {
+			lib, err := promptLibraryFromEnv()
+			if err != nil {
+				return nil, fmt.Errorf("ai_prompts: %w", err)
+			}
+
+			rows := &promptRows{prompts: lib.list(), full: true}
+			rows.addColumns(info)
+			info.SetCardinality(uint64(len(rows.prompts)), true)
+			return rows, nil
+		},
+		Init: varcharRowsInit,
+		Func: promptRowsFunc,
+	}
+}
+
+// promptRows is the bind data of ai_prompt_register and ai_prompts: library prompts as
+// (name, version, ref), plus (prompt, registered_at) when full. version is an INTEGER, so
+// the results of both join and sort with each other.
+type promptRows struct {
+	prompts []libraryPrompt
+	full    bool
+}
+
+func (r *promptRows) addColumns(info duckdbext.TableBindInfo) {
+	varchar := duckdbext.Primitive(duckdb.TypeVarchar)
+
+	info.AddResultColumn("name", varchar)
+	info.AddResultColumn("version", duckdbext.Primitive(duckdb.TypeInteger))
+	info.AddResultColumn("ref", varchar)
+	if r.full {
+		info.AddResultColumn("prompt", varchar)
+		info.AddResultColumn("registered_at", varchar)
+	}
+}
+
+// promptRowsFunc returns the rows of promptRows in one chunk, like varcharRowsFunc.
+func promptRowsFunc(scan duckdbext.TableScan, output duckdb.DataChunk) error {
+	r := scan.BindData.(*promptRows)
+	s := scan.State.(*varcharRowsState)
+
+	if s.done {
+		duckdb.DataChunkSetSize(output, 0)
+		return nil
+	}
+	s.done = true
+
+	for row, p := range r.prompts {
+		duckdbext.ChunkVector(output, 0).SetString(row, p.Name)
+		duckdbext.Set(duckdbext.ChunkVector(output, 1), row, int32(p.Version))
+		duckdbext.ChunkVector(output, 2).SetString(row, p.ref())
+		if r.full {
+			duckdbext.ChunkVector(output, 3).SetString(row, p.Prompt)
+			duckdbext.ChunkVector(output, 4).SetString(row, p.RegisteredAt.Format(time.RFC3339))
+		}
+	}
+	duckdb.DataChunkSetSize(output, duckdb.IdxT(len(r.prompts)))
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	duckdb "github.com/duckdb/duckdb-go-bindings"
+	"github.com/mlafeldt/quack-go/duckdbext"
+)
+
+type aiScoreBind struct {
+	querySettings
+	scale scoreScale
+}
+
+// scoreBind binds ai_score(text, instruction, min, max), with the options STRUCT at argument
+// options or -1 for none. integer selects the INTEGER overload. The bounds must be constant.
+func scoreBind(options int, integer bool) duckdbext.ScalarBindFunc {
+	return func(info duckdbext.ScalarBindInfo) (any, error) {
+		b := &aiScoreBind{scale: scoreScale{integer: integer}}
+		for i, bound := range []*float64{&b.scale.min, &b.scale.max} {
+			v, err := info.ConstantArgument(2 + i)
+			if err != nil {
+				return nil, fmt.Errorf("ai_score: %w", err)
+			}
+			n, ok := optionNumber(v)
+			if !ok {
+				return nil, fmt.Errorf("ai_score: %s must be a number", []string{"min", "max"}[i])
+			}
+			*bound = n
+		}
+		if err := b.scale.check(); err != nil {
+			return nil, fmt.Errorf("ai_score: %w", err)
+		}
+
+		q, err := typedBind(info, options, answerFormat{Unfused: true})
+		if err != nil {
+			return nil, fmt.Errorf("ai_score: %w", err)
+		}
+		b.querySettings = q
+		return b, nil
+	}
+}
+
+// aiScore implements ai_score(text, instruction, min, max) -> INTEGER for INTEGER bounds and
+// DOUBLE otherwise, e.g. ai_score(body, 'Rate the urgency', 1, 5). The first number of the
+// answer is taken and clamped to the bounds; an answer without a number is asked again once
+// and then becomes NULL.
+func aiScore(info duckdb.FunctionInfo, input duckdb.DataChunk, output duckdb.Vector) {
+	b, ok := duckdbext.ScalarBindData(info).(*aiScoreBind)
+	if !ok {
+		duckdbext.SetFunctionError(info, errors.New("ai_score: bind data is missing"))
+		return
+	}
+	instruction := duckdbext.ChunkVector(input, 1)
+
+	typedRows(info, input, output, typedCall{
+		name:     "ai_score",
+		settings: b.querySettings,
+		prompt: func(row int) (string, bool) {
+			if !instruction.Valid(row) {
+				return "", false
+			}
+			return b.scale.prompt(instruction.String(row)), true
+		},
+		parse: func(answer string) (any, error) {
+			return b.scale.parse(answer)
+		},
+		set: func(v duckdbext.Vector, row int, val any) {
+			if b.scale.integer {
+				duckdbext.Set(v, row, int32(val.(float64)))
+				return
+			}
+			duckdbext.Set(v, row, val.(float64))
+		},
+	})
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"time"
+
+	duckdb "github.com/duckdb/duckdb-go-bindings"
+	"github.com/mlafeldt/quack-go/duckdbext"
+)
+
+// summarizeState collects the texts of one group.
+type summarizeState struct {
+	prompt string
+	texts  []string
+
+	// aggregates get no bind callback in this C API version, so the settings and dispatch
+	// of the environment are captured when DuckDB creates the state; session settings and
+	// quackai secrets do not apply
+	settings sessionSettings
+	dispatch *dispatchSet
+}
+
+func newSummarizeState() any {
+	return &summarizeState{settings: defaultSettings(), dispatch: currentDispatch()}
+}
+
+// aiSummarizeFunction is ai_summarize(text, prompt): one summary of all non-NULL texts of a
+// group, built with as many map-reduce calls as QUACK_SUMMARY_CHARS (default 12000) requires.
+func aiSummarizeFunction() duckdbext.AggregateFunction {
+	varchar := duckdbext.Primitive(duckdb.TypeVarchar)
+
+	return duckdbext.AggregateFunction{
+		Name:     "ai_summarize",
+		Params:   []duckdbext.LogicalType{varchar, varchar},
+		Return:   varchar,
+		Init:     newSummarizeState,
+		Update:   aiSummarizeUpdate,
+		Combine:  aiSummarizeCombine,
+		Finalize: aiSummarizeFinalize,
+	}
+}
+
+func aiSummarizeUpdate(info duckdb.FunctionInfo, input duckdb.DataChunk, states []any) error {
+	textCol := duckdbext.ChunkVector(input, 0)
+	promptCol := duckdbext.ChunkVector(input, 1)
+
+	for row, st := range states {
+		s, ok := st.(*summarizeState)
+		if !ok {
+			continue
+		}
+		if !textCol.Valid(row) || !promptCol.Valid(row) {
+			continue
+		}
+		if s.prompt == "" {
+			s.prompt = promptCol.String(row)
+		}
+		s.texts = append(s.texts, textCol.String(row))
+	}
+
+	return nil
+}
+
+func aiSummarizeCombine(source, target any) error {
+	src, ok1 := source.(*summarizeState)
+	dst, ok2 := target.(*summarizeState)
+	if !ok1 || !ok2 {
+		return nil
+	}
+
+	if dst.prompt == "" {
+		dst.prompt = src.prompt
+	}
+	dst.texts = append(dst.texts, src.texts...)
+	return nil
+}
+
+func aiSummarizeFinalize(info duckdb.FunctionInfo, states []any, result duckdb.Vector, offset int) error {
+	maxChars := 12000
+	if n, ok := envInt("QUACK_SUMMARY_CHARS"); ok && n > 0 {
+		maxChars = n
+	}
+
+	summaries := make([]string, len(states))
+	valid := make([]bool, len(states))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+	defer cancel()
+
+	// the calls of all groups share one bound, like the scalar workers
+	sem := make(chan struct{}, runtime.GOMAXPROCS(0))
+	var (
+		wg     sync.WaitGroup
+		errs   rowErrors
+		strict bool
+	)
+
+	for i, st := range states {
+		s, ok := st.(*summarizeState)
+		if !ok || len(s.texts) == 0 {
+			continue
+		}
+		strict = strict || s.settings.Strict
+
+		p := s.dispatch.directProvider
+		if p == nil {
+			cancel()
+			wg.Wait()
+			return errors.New("ai_summarize: no LLM provider configured; aggregates cannot use session settings or quackai secrets, so set the API key in the environment")
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sum, err := summarizeTexts(ctx, p, sem, s.prompt, s.texts, maxChars)
+			switch {
+			case err != nil:
+				errs.add(err)
+			case sum == "":
+				errs.add(errors.New("ai_summarize: empty summary"))
+			default:
+				summaries[i], valid[i] = sum, true
+			}
+		}()
+	}
+	wg.Wait()
+
+	if strict && errs.err != nil {
+		return errs.err
+	}
+
+	out := duckdbext.NewVector(result)
+	for i := range states {
+		if !valid[i] {
+			out.SetNull(offset + i)
+			continue
+		}
+		out.SetString(offset+i, summaries[i])
+	}
+
+	return nil
+}
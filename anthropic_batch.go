@@ -17,32 +17,58 @@ type AnthropicBatchClient struct {
 }
 
 func NewAnthropicBatchClientFromEnv() (*AnthropicBatchClient, error) {
-	key := os.Getenv("ANTHROPIC_API_KEY")
+	return NewAnthropicBatchClient(os.Getenv("ANTHROPIC_API_KEY"))
+}
 
+// NewAnthropicBatchClient is NewAnthropicBatchClientFromEnv with the given key.
+func NewAnthropicBatchClient(key string) (*AnthropicBatchClient, error) {
 	if key == "" {
-		return nil, fmt.Errorf("ANTHROPIC_API_KEY is not set")
+		return nil, missingKeyError("ANTHROPIC_API_KEY")
 	}
 
 	c := anthropic.NewClient(
 		key,
-		anthropic.WithBetaVersion(anthropic.BetaMessageBatches20240924),
+		anthropicOptionsFromEnv(anthropic.WithBetaVersion(anthropic.BetaMessageBatches20240924))...,
 	)
 
 	return &AnthropicBatchClient{
 		client:    c,
-		model:     anthropic.ModelClaude3Haiku20240307,
-		maxTokens: 256,
+		model:     anthropicModel,
+		maxTokens: anthropicMaxTokens,
 	}, nil
 }
 
+func (a *AnthropicBatchClient) Name() string { return "anthropic" }
+
+func (a *AnthropicBatchClient) DefaultModel() string { return string(a.model) }
+
+func (a *AnthropicBatchClient) Capabilities() Capability { return CapBatch }
+
+func (a *AnthropicBatchClient) Complete(ctx context.Context, req CompletionRequest) (Completion, error) {
+	return createAnthropicMessage(ctx, a.client, a.model, a.maxTokens, req)
+}
+
+func (a *AnthropicBatchClient) RunBatch(
+	ctx context.Context,
+	reqs []CompletionRequest,
+	pollEvery time.Duration,
+	pollTimeout time.Duration,
+) (map[string]Completion, error) {
+	inner := make([]anthropic.InnerRequests, 0, len(reqs))
+	for _, r := range reqs {
+		inner = append(inner, buildAnthropicInnerRequest(r, a.model, a.maxTokens))
+	}
+	return a.RunMessageBatch(ctx, inner, pollEvery, pollTimeout)
+}
+
 func (a *AnthropicBatchClient) RunMessageBatch(
 	ctx context.Context,
 	reqs []anthropic.InnerRequests,
 	pollEvery time.Duration,
 	pollTimeout time.Duration,
-) (map[string]string, error) {
+) (map[string]Completion, error) {
 	if len(reqs) == 0 {
-		return map[string]string{}, nil
+		return map[string]Completion{}, nil
 	}
 
 	createResp, err := a.client.CreateBatch(ctx, anthropic.BatchRequest{Requests: reqs})
@@ -74,11 +100,10 @@ func (a *AnthropicBatchClient) RunMessageBatch(
 				return nil, wrapAnthropicErr("RetrieveBatch", err)
 			}
 
-			switch status.ProcessingStatus {
-			case "ended", "completed", "finished":
+			// in_progress and canceling batches end eventually; the items of a canceled
+			// batch come back as canceled results
+			if status.ProcessingStatus == anthropic.ProcessingStatusEnded {
 				goto DONE
-			case "failed", "canceled":
-				return nil, fmt.Errorf("batch %s ended with status=%s", batchID, status.ProcessingStatus)
 			}
 		}
 	}
@@ -90,27 +115,17 @@ DONE:
 		return nil, wrapAnthropicErr("RetrieveBatchResults", err)
 	}
 
-	out := make(map[string]string, len(reqs))
+	out := make(map[string]Completion, len(reqs))
 
 	for _, br := range resultsResp.Responses {
 		customID := br.CustomId
 
 		if br.Result.Type != anthropic.ResultTypeSucceeded {
-			out[customID] = ""
+			out[customID] = Completion{Failure: string(br.Result.Type)}
 			continue
 		}
 
-		msg := br.Result.Result
-
-		answer := ""
-		for _, block := range msg.Content {
-			t := block.GetText()
-			if t != "" {
-				answer += t
-			}
-		}
-
-		out[customID] = answer
+		out[customID] = anthropicCompletion(br.Result.Result)
 	}
 
 	return out, nil
@@ -119,7 +134,7 @@ DONE:
 func wrapAnthropicErr(where string, err error) error {
 	var apiErr *anthropic.APIError
 	if errors.As(err, &apiErr) {
-		return fmt.Errorf("%s: anthropic error type=%s message=%s", where, apiErr.Type, apiErr.Message)
+		return withKind(string(apiErr.Type), fmt.Errorf("%s: anthropic error type=%s message=%s", where, apiErr.Type, apiErr.Message))
 	}
 	return fmt.Errorf("%s: %w", where, err)
 }
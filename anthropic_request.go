@@ -3,36 +3,85 @@ package main
 import (
 	"crypto/sha1"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/liushuangls/go-anthropic/v2"
 )
 
-// creates a valid id, considering claude limitations
-func makeCustomID(row int, text, prompt string) string {
-	sum := sha1.Sum([]byte(text + "\x00" + prompt))
+// Defaults of the anthropic clients, overridden per request by CompletionRequest.
+const (
+	anthropicModel     = anthropic.ModelClaude3Haiku20240307
+	anthropicMaxTokens = 256
+
+	// anthropicSchemaTool is the tool whose input is the answer of requests with a schema.
+	anthropicSchemaTool = "record_answer"
+)
+
+// creates a valid id, considering claude limitations; the call parameters are part of the
+// hash so one text+prompt asked of two models gets two ids, and a library prompt shows up
+// as name-version, e.g. r3_sentiment-2_<hash>
+func makeCustomID(row int, req CompletionRequest) string {
+	key := req.Text + "\x00" + req.Prompt
+	if pk := req.paramsKey(); pk != "" {
+		key += "\x00" + pk
+	}
+	sum := sha1.Sum([]byte(key))
 	hash := hex.EncodeToString(sum[:6])
+	if req.PromptRef != "" {
+		name, version, _ := strings.Cut(req.PromptRef, "@")
+		if len(name) > 32 {
+			name = name[:32]
+		}
+		return fmt.Sprintf("r%d_%s-%s_%s", row, name, version, hash)
+	}
 	return fmt.Sprintf("r%d_%s", row, hash)
 }
 
-func buildAnthropicInnerRequest(customID, text, prompt string, maxTokens int) anthropic.InnerRequests {
-	user := fmt.Sprintf(
-		"TEXT:\n%s\n\nINSTRUCTION:\n%s\n\nReturn only the answer text.",
-		text, prompt,
-	)
+// anthropicParams is the Messages request for req, with model, maxTokens and the system
+// messages as defaults. Examples become user/assistant turns before user, and a schema is
+// enforced as a tool the model has to call.
+func anthropicParams(req CompletionRequest, model anthropic.Model, maxTokens int, user string, system ...string) anthropic.MessagesRequest {
+	if req.System != "" {
+		system = []string{req.System}
+	}
+
+	messages := make([]anthropic.Message, 0, 2*len(req.Examples)+1)
+	for _, ex := range req.Examples {
+		messages = append(messages,
+			anthropic.NewUserTextMessage(renderUserMessage(ex.Input, req.Prompt)),
+			anthropic.NewAssistantTextMessage(ex.Output),
+		)
+	}
+
+	params := anthropic.MessagesRequest{
+		Model:       anthropic.Model(req.modelOr(string(model))),
+		MaxTokens:   req.maxTokensOr(maxTokens),
+		MultiSystem: anthropic.NewMultiSystemMessages(system...),
+		Messages:    append(messages, anthropic.NewUserTextMessage(user)),
+	}
+	if req.Temperature != nil {
+		params.SetTemperature(float32(*req.Temperature))
+	}
+	if req.Schema != "" {
+		params.Tools = []anthropic.ToolDefinition{{
+			Name:        anthropicSchemaTool,
+			Description: "Record the answer in the given structure.",
+			InputSchema: json.RawMessage(req.Schema),
+		}}
+		params.ToolChoice = &anthropic.ToolChoice{Type: "tool", Name: anthropicSchemaTool}
+	}
+	return params
+}
 
+func buildAnthropicInnerRequest(req CompletionRequest, model anthropic.Model, maxTokens int) anthropic.InnerRequests {
 	return anthropic.InnerRequests{
-		CustomId: customID,
-		Params: anthropic.MessagesRequest{
-			Model:     anthropic.ModelClaude3Haiku20240307,
-			MaxTokens: maxTokens,
-			MultiSystem: anthropic.NewMultiSystemMessages(
-				"you are a precise assistant",
-				"follow the instruction and respond with only the answer",
-			),
-			Messages: []anthropic.Message{
-				anthropic.NewUserTextMessage(user),
-			},
-		},
+		CustomId: req.CustomID,
+		Params: anthropicParams(req, model, maxTokens,
+			renderUserMessage(req.Text, req.Prompt),
+			"you are a precise assistant",
+			"follow the instruction and respond with only the answer",
+		),
 	}
 }
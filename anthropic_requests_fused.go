@@ -6,7 +6,8 @@ import (
 	"github.com/liushuangls/go-anthropic/v2"
 )
 
-func buildAnthropicInnerRequestFused(customID, text, fusedPrompt, sep string, maxTokens int) anthropic.InnerRequests {
+// buildAnthropicInnerRequestFused asks for the answers to req.Prompt, prompts joined by sep.
+func buildAnthropicInnerRequestFused(req CompletionRequest, sep string, model anthropic.Model, maxTokens int) anthropic.InnerRequests {
 	user := fmt.Sprintf(
 		`TEXT:
 %s
@@ -18,21 +19,14 @@ Do not add numbering, quotes, extra text, or newlines. If an answer is empty, st
 
 INSTRUCTIONS_STRING:
 %s`,
-		text, sep, sep, fusedPrompt,
+		req.Text, sep, sep, req.Prompt,
 	)
 
 	return anthropic.InnerRequests{
-		CustomId: customID,
-		Params: anthropic.MessagesRequest{
-			Model:     anthropic.ModelClaude3Haiku20240307,
-			MaxTokens: maxTokens,
-			MultiSystem: anthropic.NewMultiSystemMessages(
-				"you are a precise assistant",
-				"output must be machine-parseable and strictly follow the delimiter rule",
-			),
-			Messages: []anthropic.Message{
-				anthropic.NewUserTextMessage(user),
-			},
-		},
+		CustomId: req.CustomID,
+		Params: anthropicParams(req, model, maxTokens, user,
+			"you are a precise assistant",
+			"output must be machine-parseable and strictly follow the delimiter rule",
+		),
 	}
 }
@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"os"
 	"time"
 
@@ -17,22 +16,36 @@ type AnthropicSingleClient struct {
 }
 
 func NewAnthropicSingleClientFromEnv() (*AnthropicSingleClient, error) {
-	key := os.Getenv("ANTHROPIC_API_KEY")
+	return NewAnthropicSingleClient(os.Getenv("ANTHROPIC_API_KEY"))
+}
+
+// NewAnthropicSingleClient is NewAnthropicSingleClientFromEnv with the given key.
+func NewAnthropicSingleClient(key string) (*AnthropicSingleClient, error) {
 	if key == "" {
-		return nil, fmt.Errorf("ANTHROPIC_API_KEY is not set")
+		return nil, missingKeyError("ANTHROPIC_API_KEY")
 	}
 
-	c := anthropic.NewClient(key)
+	c := anthropic.NewClient(key, anthropicOptionsFromEnv()...)
 
 	return &AnthropicSingleClient{
 		client:    c,
-		model:     anthropic.ModelClaude3Haiku20240307,
-		maxTokens: 256,
+		model:     anthropicModel,
+		maxTokens: anthropicMaxTokens,
 		timeout:   20 * time.Second,
 	}, nil
 }
 
-func (a *AnthropicSingleClient) Run(ctx context.Context, text, prompt string) (string, error) {
+func (a *AnthropicSingleClient) Name() string { return "anthropic" }
+
+func (a *AnthropicSingleClient) DefaultModel() string { return string(a.model) }
+
+func (a *AnthropicSingleClient) Capabilities() Capability { return 0 }
+
+func (a *AnthropicSingleClient) Run(ctx context.Context, text, prompt string) (Completion, error) {
+	return a.Complete(ctx, CompletionRequest{Text: text, Prompt: prompt})
+}
+
+func (a *AnthropicSingleClient) Complete(ctx context.Context, req CompletionRequest) (Completion, error) {
 	reqCtx := ctx
 
 	var cancel context.CancelFunc
@@ -41,33 +54,50 @@ func (a *AnthropicSingleClient) Run(ctx context.Context, text, prompt string) (s
 		defer cancel()
 	}
 
-	user := fmt.Sprintf(
-		"TEXT:\n%s\n\nINSTRUCTION:\n%s\n\nReturn only the answer text.",
-		text, prompt,
-	)
-
-	resp, err := a.client.CreateMessages(reqCtx, anthropic.MessagesRequest{
-		Model: a.model,
-		MultiSystem: anthropic.NewMultiSystemMessages(
-			"you are a precise assistant",
-			"follow the instruction and respond with only the answer",
-		),
-		Messages: []anthropic.Message{
-			anthropic.NewUserTextMessage(user),
-		},
-		MaxTokens: a.maxTokens,
-	})
+	return createAnthropicMessage(reqCtx, a.client, a.model, a.maxTokens, req)
+}
+
+// anthropicOptionsFromEnv adds ANTHROPIC_BASE_URL (a proxy, or an anthropictest.Server) to opts.
+func anthropicOptionsFromEnv(opts ...anthropic.ClientOption) []anthropic.ClientOption {
+	if u := os.Getenv("ANTHROPIC_BASE_URL"); u != "" {
+		opts = append(opts, anthropic.WithBaseURL(u))
+	}
+	return opts
+}
+
+func createAnthropicMessage(ctx context.Context, client *anthropic.Client, model anthropic.Model, maxTokens int, req CompletionRequest) (Completion, error) {
+	resp, err := client.CreateMessages(ctx, anthropicParams(req, model, maxTokens,
+		renderUserMessage(req.Text, req.Prompt),
+		"you are a precise assistant",
+		"follow the instruction and respond with only the answer",
+	))
 
 	if err != nil {
-		return "", wrapAnthropicErr("CreateMessages", err)
+		return Completion{}, wrapAnthropicErr("CreateMessages", err)
 	}
 
+	return anthropicCompletion(resp), nil
+}
+
+func anthropicCompletion(resp anthropic.MessagesResponse) Completion {
 	out := ""
 	for _, block := range resp.Content {
+		// requests with a schema are answered by the input of the forced tool call
+		if block.Type == anthropic.MessagesContentTypeToolUse && block.MessageContentToolUse != nil {
+			out += string(block.Input)
+			continue
+		}
 		t := block.GetText()
 		if t != "" {
 			out += t
 		}
 	}
-	return out, nil
+	return Completion{
+		Text:  out,
+		Model: string(resp.Model),
+		Usage: Usage{
+			InputTokens:  resp.Usage.InputTokens,
+			OutputTokens: resp.Usage.OutputTokens,
+		},
+	}
 }
@@ -0,0 +1,441 @@
+// Package anthropictest provides a local fake of the Anthropic Messages and Message Batches
+// APIs for end-to-end tests, in the spirit of net/http/httptest.
+//
+//	srv := anthropictest.NewServer()
+//	defer srv.Close()
+//	os.Setenv("ANTHROPIC_BASE_URL", srv.BaseURL())
+//	os.Setenv("ANTHROPIC_API_KEY", "test")
+//
+// Behavior is scripted through the setters: latency, queued API errors (429, 529, ...),
+// per-request batch result types and how a batch moves through its processing statuses.
+// cmd/fakeanthropic serves one for the end-to-end tests of the Makefile.
+package anthropictest
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/liushuangls/go-anthropic/v2"
+)
+
+// AnswerFunc renders the reply text for a request.
+type AnswerFunc func(req anthropic.MessagesRequest) string
+
+// ResultFunc picks the batch result type for a custom_id.
+type ResultFunc func(customID string) anthropic.ResultType
+
+type scriptedError struct {
+	status  int
+	errType string
+	message string
+}
+
+type batch struct {
+	id       string
+	requests []anthropic.InnerRequests
+	polls    int
+	created  time.Time
+	canceled time.Time // when cancellation was initiated; zero if never
+}
+
+// Server is a fake Anthropic API. All setters are safe for concurrent use.
+type Server struct {
+	*httptest.Server
+
+	mu sync.Mutex
+
+	answer      AnswerFunc
+	result      ResultFunc
+	latency     time.Duration
+	errs        []scriptedError
+	batchPolls  int
+	batchStatus anthropic.ProcessingStatus
+
+	batches  map[string]*batch
+	nextID   int
+	messages int
+	retrieve int
+}
+
+// NewServer starts a fake API. By default every message is answered with a deterministic
+// "answer-<hash>" text, batches end after the first poll and every batch item succeeds.
+func NewServer() *Server {
+	s := &Server{
+		answer:      defaultAnswer,
+		result:      func(string) anthropic.ResultType { return anthropic.ResultTypeSucceeded },
+		batchPolls:  1,
+		batchStatus: anthropic.ProcessingStatusEnded,
+		batches:     make(map[string]*batch),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/messages", s.handleMessages)
+	mux.HandleFunc("POST /v1/messages/batches", s.handleCreateBatch)
+	mux.HandleFunc("GET /v1/messages/batches/{id}", s.handleRetrieveBatch)
+	mux.HandleFunc("GET /v1/messages/batches/{id}/results", s.handleBatchResults)
+
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// BaseURL is the value for ANTHROPIC_BASE_URL.
+func (s *Server) BaseURL() string {
+	return s.URL + "/v1"
+}
+
+// SetAnswer replaces the function that renders reply texts.
+func (s *Server) SetAnswer(f AnswerFunc) {
+	s.mu.Lock()
+	s.answer = f
+	s.mu.Unlock()
+}
+
+// SetLatency delays every response by d.
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	s.latency = d
+	s.mu.Unlock()
+}
+
+// FailNext makes the next n requests (any endpoint) fail with the given HTTP status and
+// Anthropic error type, e.g. FailNext(2, 429, "rate_limit_error") or FailNext(1, 529, "overloaded_error").
+func (s *Server) FailNext(n, status int, errType string) {
+	s.mu.Lock()
+	for i := 0; i < n; i++ {
+		s.errs = append(s.errs, scriptedError{
+			status:  status,
+			errType: errType,
+			message: fmt.Sprintf("scripted %s", errType),
+		})
+	}
+	s.mu.Unlock()
+}
+
+// SetBatchResult picks the result type per custom_id, for partial batch failures.
+func (s *Server) SetBatchResult(f ResultFunc) {
+	s.mu.Lock()
+	s.result = f
+	s.mu.Unlock()
+}
+
+// SetBatchProcessing makes batches report in_progress for polls retrievals and then status:
+//   - ended: the batch ends with the results of SetBatchResult
+//   - canceling: the batch is being canceled for one retrieval, then ends with every
+//     request canceled
+//   - in_progress: the batch never ends, to exercise poll timeouts
+func (s *Server) SetBatchProcessing(polls int, status anthropic.ProcessingStatus) {
+	switch status {
+	case anthropic.ProcessingStatusEnded, anthropic.ProcessingStatusCanceling, anthropic.ProcessingStatusInProgress:
+	default:
+		panic(fmt.Sprintf("anthropictest: unknown processing status %q", status))
+	}
+
+	s.mu.Lock()
+	s.batchPolls = polls
+	s.batchStatus = status
+	s.mu.Unlock()
+}
+
+// MessageCount is the number of successful /v1/messages calls.
+func (s *Server) MessageCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.messages
+}
+
+// BatchCount is the number of batches created.
+func (s *Server) BatchCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.batches)
+}
+
+// RetrieveCount is the number of batch status polls.
+func (s *Server) RetrieveCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.retrieve
+}
+
+// begin applies latency and scripted errors; it reports false if the response was written.
+func (s *Server) begin(w http.ResponseWriter, r *http.Request) bool {
+	s.mu.Lock()
+	latency := s.latency
+	var e *scriptedError
+	if len(s.errs) > 0 {
+		e = &s.errs[0]
+		s.errs = s.errs[1:]
+	}
+	s.mu.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return false
+		}
+	}
+
+	if r.Header.Get("x-api-key") == "" {
+		writeError(w, http.StatusUnauthorized, "authentication_error", "x-api-key header is required")
+		return false
+	}
+	if e != nil {
+		writeError(w, e.status, e.errType, e.message)
+		return false
+	}
+	return true
+}
+
+func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
+	if !s.begin(w, r) {
+		return
+	}
+
+	var req anthropic.MessagesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	if msg := validate(req); msg != "" {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", msg)
+		return
+	}
+
+	s.mu.Lock()
+	s.messages++
+	id := s.messages
+	answer := s.answer
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, message(fmt.Sprintf("msg_%d", id), req, answer(req)))
+}
+
+func (s *Server) handleCreateBatch(w http.ResponseWriter, r *http.Request) {
+	if !s.begin(w, r) {
+		return
+	}
+
+	var req anthropic.BatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	if len(req.Requests) == 0 {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "requests: must not be empty")
+		return
+	}
+	for _, ir := range req.Requests {
+		if ir.CustomId == "" || len(ir.CustomId) > 64 {
+			writeError(w, http.StatusBadRequest, "invalid_request_error", "custom_id: must be 1-64 characters")
+			return
+		}
+		if msg := validate(ir.Params); msg != "" {
+			writeError(w, http.StatusBadRequest, "invalid_request_error", ir.CustomId+": "+msg)
+			return
+		}
+	}
+
+	s.mu.Lock()
+	s.nextID++
+	b := &batch{
+		id:       fmt.Sprintf("msgbatch_%d", s.nextID),
+		requests: req.Requests,
+		created:  time.Now().UTC(),
+	}
+	s.batches[b.id] = b
+	body := s.batchBody(b, anthropic.ProcessingStatusInProgress)
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, body)
+}
+
+func (s *Server) handleRetrieveBatch(w http.ResponseWriter, r *http.Request) {
+	if !s.begin(w, r) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.retrieve++
+	b := s.batches[r.PathValue("id")]
+	if b == nil {
+		writeError(w, http.StatusNotFound, "not_found_error", "batch not found")
+		return
+	}
+
+	b.polls++
+	status := anthropic.ProcessingStatusInProgress
+	switch {
+	case b.polls < s.batchPolls:
+	case s.batchStatus != anthropic.ProcessingStatusCanceling:
+		status = s.batchStatus
+	case b.canceled.IsZero():
+		b.canceled = time.Now().UTC()
+		status = anthropic.ProcessingStatusCanceling
+	default:
+		status = anthropic.ProcessingStatusEnded
+	}
+	writeJSON(w, http.StatusOK, s.batchBody(b, status))
+}
+
+func (s *Server) handleBatchResults(w http.ResponseWriter, r *http.Request) {
+	if !s.begin(w, r) {
+		return
+	}
+
+	s.mu.Lock()
+	b := s.batches[r.PathValue("id")]
+	var types []anthropic.ResultType
+	if b != nil {
+		for _, ir := range b.requests {
+			types = append(types, s.resultType(b, ir.CustomId))
+		}
+	}
+	answer := s.answer
+	s.mu.Unlock()
+
+	if b == nil {
+		writeError(w, http.StatusNotFound, "not_found_error", "batch not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/binary")
+	w.WriteHeader(http.StatusOK)
+
+	enc := json.NewEncoder(w)
+	for i, ir := range b.requests {
+		rt := types[i]
+		line := map[string]any{"custom_id": ir.CustomId}
+
+		switch rt {
+		case anthropic.ResultTypeSucceeded:
+			line["result"] = map[string]any{
+				"type":    rt,
+				"message": message(fmt.Sprintf("msg_%s_%d", b.id, i), ir.Params, answer(ir.Params)),
+			}
+		case anthropic.ResultTypeErrored:
+			line["result"] = map[string]any{
+				"type":  rt,
+				"error": errorBody("api_error", "scripted batch item failure"),
+			}
+		default:
+			line["result"] = map[string]any{"type": rt}
+		}
+		_ = enc.Encode(line)
+	}
+}
+
+// resultType is the result type of the request customID of b: canceled once b was canceled,
+// else what SetBatchResult picks. Callers hold s.mu.
+func (s *Server) resultType(b *batch, customID string) anthropic.ResultType {
+	if !b.canceled.IsZero() {
+		return anthropic.ResultTypeCanceled
+	}
+	return s.result(customID)
+}
+
+// batchBody renders a message_batch object; callers hold s.mu.
+func (s *Server) batchBody(b *batch, status anthropic.ProcessingStatus) map[string]any {
+	// request_counts has a field per result type, and processing for unfinished requests
+	counts := map[string]int{"processing": 0, "succeeded": 0, "errored": 0, "canceled": 0, "expired": 0}
+	if status != anthropic.ProcessingStatusEnded {
+		counts["processing"] = len(b.requests)
+	} else {
+		for _, ir := range b.requests {
+			counts[string(s.resultType(b, ir.CustomId))]++
+		}
+	}
+
+	body := map[string]any{
+		"id":                  b.id,
+		"type":                "message_batch",
+		"processing_status":   status,
+		"request_counts":      counts,
+		"created_at":          b.created,
+		"expires_at":          b.created.Add(24 * time.Hour),
+		"ended_at":            nil,
+		"archived_at":         nil,
+		"cancel_initiated_at": nil,
+		"results_url":         nil,
+	}
+	if !b.canceled.IsZero() {
+		body["cancel_initiated_at"] = b.canceled
+	}
+	if status == anthropic.ProcessingStatusEnded {
+		body["ended_at"] = time.Now().UTC()
+		body["results_url"] = s.URL + "/v1/messages/batches/" + b.id + "/results"
+	}
+	return body
+}
+
+func validate(req anthropic.MessagesRequest) string {
+	switch {
+	case req.Model == "":
+		return "model: field required"
+	case req.MaxTokens <= 0:
+		return "max_tokens: must be positive"
+	case len(req.Messages) == 0:
+		return "messages: must not be empty"
+	}
+	return ""
+}
+
+func message(id string, req anthropic.MessagesRequest, text string) map[string]any {
+	in := 0
+	for _, m := range req.Messages {
+		for _, c := range m.Content {
+			in += len(c.GetText())
+		}
+	}
+
+	return map[string]any{
+		"id":            id,
+		"type":          "message",
+		"role":          "assistant",
+		"model":         req.Model,
+		"content":       []map[string]any{{"type": "text", "text": text}},
+		"stop_reason":   "end_turn",
+		"stop_sequence": nil,
+		"usage": map[string]int{
+			"input_tokens":  (in + 3) / 4,
+			"output_tokens": (len(text) + 3) / 4,
+		},
+	}
+}
+
+func defaultAnswer(req anthropic.MessagesRequest) string {
+	var sb strings.Builder
+	for _, m := range req.Messages {
+		for _, c := range m.Content {
+			sb.WriteString(c.GetText())
+		}
+	}
+	sum := sha1.Sum([]byte(sb.String()))
+	return "answer-" + hex.EncodeToString(sum[:4])
+}
+
+func errorBody(errType, message string) map[string]any {
+	return map[string]any{
+		"type":  "error",
+		"error": map[string]string{"type": errType, "message": message},
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, errType, message string) {
+	writeJSON(w, status, errorBody(errType, message))
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -5,39 +5,36 @@ import (
 	"os"
 )
 
-var (
+// dispatchSet is the dispatch state of one configuration; exactly one of dispatcher,
+// singleProvider and fusedDispatcher is set, per mode.
+type dispatchSet struct {
 	dispatcher      *LLMDispatcher
-	singleClient    *AnthropicSingleClient
+	singleProvider  Provider
 	fusedDispatcher *FusedDispatcher
-)
 
-func init() {
+	// multiDispatcher serves multi-prompt calls in every mode
+	multiDispatcher *FusedDispatcher
+
+	// directProvider serves calls not tied to rows, such as aggregates, in every mode
+	directProvider Provider
+}
+
+// defaultDispatch is configured from the environment. Sessions with their own settings get
+// theirs from dispatchFor.
+var defaultDispatch = &dispatchSet{}
+
+// initArrowDispatch configures defaultDispatch for the Arrow standalone binary. It is not a
+// package init: the extension shares this package and sets up its own dispatch on LOAD,
+// where the API key may still come from a quackai secret.
+func initArrowDispatch() {
 	mode := os.Getenv("QUACK_LLM_MODE")
 
-	dispatcher = nil
-	singleClient = nil
-	fusedDispatcher = nil
-
-	switch mode {
-	case "single":
-		c, err := NewAnthropicSingleClientFromEnv()
-		if err != nil {
-			panic(fmt.Sprintf("Failed to init single client: %v", err))
-		}
-		singleClient = c
-
-	case "fused":
-		c, err := NewAnthropicSingleClientFromEnv()
-		if err != nil {
-			panic(fmt.Sprintf("Failed to init single client (for fused mode): %v", err))
-		}
-		fusedDispatcher = NewFusedDispatcher(c, ";")
-
-	default:
-		c, err := NewAnthropicBatchClientFromEnv()
-		if err != nil {
-			panic(fmt.Sprintf("Failed to init batch client: %v", err))
-		}
-		dispatcher = NewLLMDispatcher(c)
+	p, err := newProviderFromEnv(mode, "")
+	if err != nil {
+		panic(fmt.Sprintf("Failed to init provider: %v", err))
+	}
+
+	if err := setupDispatch(mode, p); err != nil {
+		panic(fmt.Sprintf("Failed to init %s dispatch: %v", mode, err))
 	}
 }
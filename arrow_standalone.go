@@ -40,6 +40,7 @@ func RecordUpstreamRequest(d time.Duration) {
 }
 
 func aiLLMBatch(
+	ds *dispatchSet,
 	texts []string, textValid []bool,
 	prompts []string, promptValid []bool,
 	parallel int,
@@ -48,7 +49,7 @@ func aiLLMBatch(
 	out := make([]string, n)
 	outValid := make([]bool, n)
 
-	if fusedDispatcher == nil && singleClient == nil && dispatcher == nil {
+	if ds.fusedDispatcher == nil && ds.singleProvider == nil && ds.dispatcher == nil {
 		return out, outValid
 	}
 
@@ -56,7 +57,7 @@ func aiLLMBatch(
 		parallel = runtime.GOMAXPROCS(0)
 	}
 
-	if fusedDispatcher != nil {
+	if ds.fusedDispatcher != nil {
 		type job struct {
 			i      int
 			text   string
@@ -70,7 +71,7 @@ func aiLLMBatch(
 			go func() {
 				defer wg.Done()
 				for j := range jobCh {
-					ans, err := fusedDispatcher.GetResult(j.text, j.prompt)
+					ans, err := ds.fusedDispatcher.GetResult(j.text, j.prompt)
 					if err != nil || ans == "" {
 						outValid[j.i] = false
 						continue
@@ -94,7 +95,7 @@ func aiLLMBatch(
 		return out, outValid
 	}
 
-	if singleClient != nil {
+	if ds.singleProvider != nil {
 		type job struct {
 			i      int
 			text   string
@@ -112,14 +113,14 @@ func aiLLMBatch(
 				defer wg.Done()
 				for j := range jobCh {
 					t0 := time.Now()
-					ans, err := singleClient.Run(ctx, j.text, j.prompt)
+					c, err := ds.singleProvider.Complete(ctx, CompletionRequest{Text: j.text, Prompt: j.prompt})
 					RecordUpstreamRequest(time.Since(t0))
 
-					if err != nil || ans == "" {
+					if err != nil || c.Text == "" {
 						outValid[j.i] = false
 						continue
 					}
-					out[j.i] = ans
+					out[j.i] = c.Text
 					outValid[j.i] = true
 				}
 			}()
@@ -175,7 +176,7 @@ func aiLLMBatch(
 	defer cancel()
 
 	t0 := time.Now()
-	resMap, err := dispatcher.Submit(ctx, jobs)
+	resMap, err := ds.dispatcher.Submit(ctx, jobs)
 	RecordUpstreamRequest(time.Since(t0))
 
 	if err != nil {
@@ -186,7 +187,7 @@ func aiLLMBatch(
 	}
 
 	for _, r := range refs {
-		ans := resMap[r.cid]
+		ans := resMap[r.cid].Text
 		if ans == "" {
 			outValid[r.i] = false
 			continue
@@ -197,7 +198,56 @@ func aiLLMBatch(
 	return out, outValid
 }
 
+// enrichTexts runs every prompt over texts through ds and returns the answers per prompt. In
+// fused mode the prompts run concurrently so they get fused per text.
+func enrichTexts(ds *dispatchSet, texts []string, textValid []bool, promptList []string, parallel int) ([][]string, [][]bool) {
+	n := len(texts)
+
+	results := make([][]string, len(promptList))
+	valids := make([][]bool, len(promptList))
+	for p := range promptList {
+		results[p] = make([]string, n)
+		valids[p] = make([]bool, n)
+	}
+
+	makePromptArray := func(p string) (arr []string, pValid []bool) {
+		arr = make([]string, n)
+		pValid = make([]bool, n)
+		for i := 0; i < n; i++ {
+			pValid[i] = textValid[i]
+			arr[i] = p
+		}
+		return
+	}
+
+	if ds.fusedDispatcher != nil {
+		var wg sync.WaitGroup
+		wg.Add(len(promptList))
+
+		for pi := range promptList {
+			pi := pi
+			pArr, pValid := makePromptArray(promptList[pi])
+
+			go func() {
+				defer wg.Done()
+				results[pi], valids[pi] = aiLLMBatch(ds, texts, textValid, pArr, pValid, parallel)
+			}()
+		}
+
+		wg.Wait()
+	} else {
+		for pi := range promptList {
+			pArr, pValid := makePromptArray(promptList[pi])
+			results[pi], valids[pi] = aiLLMBatch(ds, texts, textValid, pArr, pValid, parallel)
+		}
+	}
+
+	return results, valids
+}
+
 func main() {
+	initArrowDispatch()
+
 	const inPath = "animals.arrow"
 
 	promptList := []string{
@@ -218,11 +268,11 @@ func main() {
 
 	mode := "none"
 	switch {
-	case fusedDispatcher != nil:
+	case defaultDispatch.fusedDispatcher != nil:
 		mode = "fused"
-	case singleClient != nil:
+	case defaultDispatch.singleProvider != nil:
 		mode = "single"
-	case dispatcher != nil:
+	case defaultDispatch.dispatcher != nil:
 		mode = "batch" // unstable due to high API response times :(
 	}
 	fmt.Fprintln(os.Stderr, "MODE =", mode)
@@ -281,44 +331,7 @@ func main() {
 		}
 		texts, textValid := extractStringColumn(textArr, n)
 
-		results := make([][]string, len(promptList))
-		valids := make([][]bool, len(promptList))
-		for p := range promptList {
-			results[p] = make([]string, n)
-			valids[p] = make([]bool, n)
-		}
-
-		makePromptArray := func(p string) (arr []string, pValid []bool) {
-			arr = make([]string, n)
-			pValid = make([]bool, n)
-			for i := 0; i < n; i++ {
-				pValid[i] = textValid[i]
-				arr[i] = p
-			}
-			return
-		}
-
-		if mode == "fused" {
-			var wg sync.WaitGroup
-			wg.Add(len(promptList))
-
-			for pi := range promptList {
-				pi := pi
-				pArr, pValid := makePromptArray(promptList[pi])
-
-				go func() {
-					defer wg.Done()
-					results[pi], valids[pi] = aiLLMBatch(texts, textValid, pArr, pValid, parallel)
-				}()
-			}
-
-			wg.Wait()
-		} else {
-			for pi := range promptList {
-				pArr, pValid := makePromptArray(promptList[pi])
-				results[pi], valids[pi] = aiLLMBatch(texts, textValid, pArr, pValid, parallel)
-			}
-		}
+		results, valids := enrichTexts(defaultDispatch, texts, textValid, promptList, parallel)
 
 		if !printedHeader {
 			fmt.Print("id\tname")
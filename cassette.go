@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+type cassetteMode string
+
+const (
+	cassetteAuto   cassetteMode = "auto"   // replay recorded requests, record the rest
+	cassetteRecord cassetteMode = "record" // always call the provider and record
+	cassetteReplay cassetteMode = "replay" // never call the provider
+)
+
+// cassetteEntry is one line of a cassette file.
+type cassetteEntry struct {
+	Kind       string            `json:"kind"` // "complete" or "batch"
+	Provider   string            `json:"provider"`
+	Request    CompletionRequest `json:"request"`
+	Response   Completion        `json:"response"`
+	Error      string            `json:"error,omitempty"`
+	ErrorKind  string            `json:"error_kind,omitempty"`
+	RecordedAt time.Time         `json:"recorded_at"`
+}
+
+// CassetteProvider records every request/response pair of the wrapped provider to a JSON lines
+// file and replays them later. Repeated requests replay in recorded order (so retries after a
+// recorded error replay too); once exhausted the last recording is repeated.
+type CassetteProvider struct {
+	inner Provider // nil when replaying
+	mode  cassetteMode
+
+	mu      sync.Mutex
+	entries map[string][]cassetteEntry
+	cursor  map[string]int
+	file    *os.File
+}
+
+func NewCassetteProvider(path string, mode cassetteMode, inner Provider) (*CassetteProvider, error) {
+	switch mode {
+	case "":
+		mode = cassetteAuto
+	case cassetteAuto, cassetteRecord, cassetteReplay:
+	default:
+		return nil, fmt.Errorf("unknown cassette mode %q", mode)
+	}
+	if mode != cassetteReplay && inner == nil {
+		return nil, fmt.Errorf("cassette mode %s needs a provider", mode)
+	}
+
+	c := &CassetteProvider{
+		inner:   inner,
+		mode:    mode,
+		entries: make(map[string][]cassetteEntry),
+		cursor:  make(map[string]int),
+	}
+
+	if err := c.load(path); err != nil {
+		return nil, err
+	}
+
+	if mode != cassetteReplay {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("open cassette: %w", err)
+		}
+		c.file = f
+	}
+
+	return c, nil
+}
+
+func (c *CassetteProvider) load(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		if c.mode == cassetteReplay {
+			return fmt.Errorf("cassette %s does not exist", path)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open cassette: %w", err)
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var e cassetteEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return fmt.Errorf("cassette %s line %d: %w", path, line, err)
+		}
+		k := cassetteKey(e.Request)
+		c.entries[k] = append(c.entries[k], e)
+	}
+	return sc.Err()
+}
+
+// cassetteKey identifies a request by its content; custom IDs may differ between runs.
+func cassetteKey(req CompletionRequest) string {
+	req.CustomID = ""
+	b, _ := json.Marshal(req)
+	sum := sha1.Sum(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *CassetteProvider) Name() string {
+	if c.inner == nil {
+		return "cassette"
+	}
+	return "cassette(" + c.inner.Name() + ")"
+}
+
+func (c *CassetteProvider) DefaultModel() string {
+	if m, ok := c.inner.(defaultModeler); ok {
+		return m.DefaultModel()
+	}
+	return ""
+}
+
+func (c *CassetteProvider) Capabilities() Capability {
+	if c.inner == nil {
+		return CapBatch
+	}
+	return c.inner.Capabilities()
+}
+
+func (c *CassetteProvider) Complete(ctx context.Context, req CompletionRequest) (Completion, error) {
+	if e, ok := c.replay(req); ok {
+		if e.Error != "" {
+			if e.ErrorKind != "" {
+				return Completion{}, withKind(e.ErrorKind, errors.New(e.Error))
+			}
+			return Completion{}, errors.New(e.Error)
+		}
+		return e.Response, nil
+	}
+	if c.mode == cassetteReplay {
+		return Completion{}, fmt.Errorf("cassette: no recording for request")
+	}
+
+	resp, err := c.inner.Complete(ctx, req)
+	c.record("complete", req, resp, err)
+	return resp, err
+}
+
+func (c *CassetteProvider) RunBatch(
+	ctx context.Context,
+	reqs []CompletionRequest,
+	pollEvery time.Duration,
+	pollTimeout time.Duration,
+) (map[string]Completion, error) {
+	out := make(map[string]Completion, len(reqs))
+	missing := make([]CompletionRequest, 0)
+
+	for _, r := range reqs {
+		if e, ok := c.replay(r); ok {
+			out[r.CustomID] = e.Response
+			continue
+		}
+		missing = append(missing, r)
+	}
+
+	if len(missing) == 0 {
+		return out, nil
+	}
+	if c.mode == cassetteReplay {
+		return out, fmt.Errorf("cassette: %d of %d batch requests not recorded", len(missing), len(reqs))
+	}
+
+	bp, ok := c.inner.(BatchProvider)
+	if !ok {
+		return out, fmt.Errorf("provider %s does not support batch mode", c.inner.Name())
+	}
+
+	res, err := bp.RunBatch(ctx, missing, pollEvery, pollTimeout)
+	if err != nil {
+		return out, err
+	}
+	for _, r := range missing {
+		resp, ok := res[r.CustomID]
+		if !ok {
+			continue
+		}
+		// failed items are not recorded, or they would replay as empty answers for good;
+		// the next run asks for them again
+		if resp.Failure == "" {
+			c.record("batch", r, resp, nil)
+		}
+		out[r.CustomID] = resp
+	}
+	return out, nil
+}
+
+func (c *CassetteProvider) replay(req CompletionRequest) (cassetteEntry, bool) {
+	if c.mode == cassetteRecord {
+		return cassetteEntry{}, false
+	}
+
+	k := cassetteKey(req)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	list := c.entries[k]
+	if len(list) == 0 {
+		return cassetteEntry{}, false
+	}
+
+	i := c.cursor[k]
+	if i >= len(list) {
+		// exhausted: repeat the last outcome, unless it was an error we can retry for real
+		if c.mode == cassetteAuto && list[len(list)-1].Error != "" {
+			return cassetteEntry{}, false
+		}
+		return list[len(list)-1], true
+	}
+	c.cursor[k] = i + 1
+	return list[i], true
+}
+
+func (c *CassetteProvider) record(kind string, req CompletionRequest, resp Completion, err error) {
+	e := cassetteEntry{
+		Kind:       kind,
+		Provider:   c.inner.Name(),
+		Request:    req,
+		Response:   resp,
+		RecordedAt: time.Now().UTC(),
+	}
+	if err != nil {
+		e.Error = err.Error()
+		e.ErrorKind = errorKind(err)
+	}
+
+	line, mErr := json.Marshal(e)
+	if mErr != nil {
+		return
+	}
+
+	k := cassetteKey(req)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[k] = append(c.entries[k], e)
+	c.cursor[k] = len(c.entries[k])
+	if _, wErr := c.file.Write(append(line, '\n')); wErr != nil {
+		fmt.Fprintln(os.Stderr, "cassette write:", wErr)
+	}
+}
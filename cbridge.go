@@ -22,6 +22,20 @@ package main
 //    - DuckDB calls scalarFunctionWrapper (C callback)
 //    - Forwards to goScalarDispatch (Go function)
 //    - Dispatches to the registered Go implementation via cgo.Handle
+//    - scalarBindWrapper forwards the optional bind to goScalarBind; bind data is copied
+//      through bindDataCopyWrapper / goCopyHandle
+//
+// 4. Table functions
+//    - tableBindWrapper / tableInitWrapper / tableLocalInitWrapper / tableFunctionWrapper
+//      forward to goTableBind / goTableInit / goTableLocalInit / goTableDispatch
+//    - duckdbext keeps the Go TableFunction in extra_info and the bind data, global
+//      and per-thread scan state in bind/init/local init data, each behind its own cgo.Handle
+//
+// 5. Aggregate functions
+//    - aggregateStateSizeWrapper sizes each group state to hold one cgo.Handle
+//    - aggregateInitWrapper / aggregateUpdateWrapper / aggregateCombineWrapper /
+//      aggregateFinalizeWrapper / aggregateDestroyWrapper forward to goAggregate*
+//    - duckdbext keeps the Go AggregateFunction in extra_info
 
 /*
 #cgo CFLAGS: -I./include -DDUCKDB_EXTENSION_NAME=quack -DDUCKDB_BUILD_LOADABLE_EXTENSION=1
@@ -43,16 +57,71 @@ DUCKDB_CAPI_ENTRY_VISIBILITY __attribute__((used)) void extension_set_error(stru
     access->set_error(info, msg);
 }
 
+DUCKDB_CAPI_ENTRY_VISIBILITY __attribute__((used)) duckdb_state extension_connect(struct duckdb_extension_access *access, duckdb_extension_info info, duckdb_connection *out) {
+    duckdb_database *db = access->get_database(info);
+    if (!db) {
+        return DuckDBError;
+    }
+    return duckdb_connect(*db, out);
+}
+
 // Go entrypoints
 extern bool initExtension(duckdb_connection connection, duckdb_extension_info info, struct duckdb_extension_access *access);
 extern void goScalarDispatch(duckdb_function_info info, duckdb_data_chunk input, duckdb_vector output);
 extern void goDeleteHandle(void *ptr);
+extern void goScalarBind(duckdb_bind_info info);
+extern void *goCopyHandle(void *ptr);
+extern void goTableBind(duckdb_bind_info info);
+extern void goTableInit(duckdb_init_info info);
+extern void goTableLocalInit(duckdb_init_info info);
+extern void goTableDispatch(duckdb_function_info info, duckdb_data_chunk output);
+extern void goAggregateInit(duckdb_function_info info, duckdb_aggregate_state state);
+extern void goAggregateUpdate(duckdb_function_info info, duckdb_data_chunk input, duckdb_aggregate_state *states);
+extern void goAggregateCombine(duckdb_function_info info, duckdb_aggregate_state *source, duckdb_aggregate_state *target, idx_t count);
+extern void goAggregateFinalize(duckdb_function_info info, duckdb_aggregate_state *source, duckdb_vector result, idx_t count, idx_t offset);
+extern void goAggregateDestroy(duckdb_aggregate_state *states, idx_t count);
 
 // Trampoline + entrypoint
 __attribute__((weak)) void scalarFunctionWrapper(duckdb_function_info info, duckdb_data_chunk input, duckdb_vector output) {
     goScalarDispatch(info, input, output);
 }
 
+__attribute__((weak)) void scalarBindWrapper(duckdb_bind_info info) { goScalarBind(info); }
+
+__attribute__((weak)) void *bindDataCopyWrapper(void *ptr) { return goCopyHandle(ptr); }
+
+__attribute__((weak)) void tableBindWrapper(duckdb_bind_info info) { goTableBind(info); }
+
+__attribute__((weak)) void tableInitWrapper(duckdb_init_info info) { goTableInit(info); }
+
+__attribute__((weak)) void tableLocalInitWrapper(duckdb_init_info info) { goTableLocalInit(info); }
+
+__attribute__((weak)) void tableFunctionWrapper(duckdb_function_info info, duckdb_data_chunk output) {
+    goTableDispatch(info, output);
+}
+
+__attribute__((weak)) idx_t aggregateStateSizeWrapper(duckdb_function_info info) { return sizeof(uintptr_t); }
+
+__attribute__((weak)) void aggregateInitWrapper(duckdb_function_info info, duckdb_aggregate_state state) {
+    goAggregateInit(info, state);
+}
+
+__attribute__((weak)) void aggregateUpdateWrapper(duckdb_function_info info, duckdb_data_chunk input, duckdb_aggregate_state *states) {
+    goAggregateUpdate(info, input, states);
+}
+
+__attribute__((weak)) void aggregateCombineWrapper(duckdb_function_info info, duckdb_aggregate_state *source, duckdb_aggregate_state *target, idx_t count) {
+    goAggregateCombine(info, source, target, count);
+}
+
+__attribute__((weak)) void aggregateFinalizeWrapper(duckdb_function_info info, duckdb_aggregate_state *source, duckdb_vector result, idx_t count, idx_t offset) {
+    goAggregateFinalize(info, source, result, count, offset);
+}
+
+__attribute__((weak)) void aggregateDestroyWrapper(duckdb_aggregate_state *states, idx_t count) {
+    goAggregateDestroy(states, count);
+}
+
 __attribute__((weak)) void extraInfoDestroy(void *ptr) { goDeleteHandle(ptr); }
 
 // DuckDB will set duckdb_ext_api for us and hand us an open connection.
@@ -82,3 +151,76 @@ func goScalarDispatch(info C.duckdb_function_info, input C.duckdb_data_chunk, ou
 func goDeleteHandle(ptr unsafe.Pointer) {
 	duckdbext.DeleteHandle(ptr)
 }
+
+//export goScalarBind
+func goScalarBind(info C.duckdb_bind_info) {
+	duckdbext.ScalarBind(duckdb.BindInfo{Ptr: unsafe.Pointer(info)})
+}
+
+//export goCopyHandle
+func goCopyHandle(ptr unsafe.Pointer) unsafe.Pointer {
+	return duckdbext.CopyHandle(ptr)
+}
+
+//export goTableBind
+func goTableBind(info C.duckdb_bind_info) {
+	duckdbext.TableBind(duckdb.BindInfo{Ptr: unsafe.Pointer(info)})
+}
+
+//export goTableInit
+func goTableInit(info C.duckdb_init_info) {
+	duckdbext.TableInit(duckdb.InitInfo{Ptr: unsafe.Pointer(info)})
+}
+
+//export goTableLocalInit
+func goTableLocalInit(info C.duckdb_init_info) {
+	duckdbext.TableLocalInit(duckdb.InitInfo{Ptr: unsafe.Pointer(info)})
+}
+
+//export goTableDispatch
+func goTableDispatch(info C.duckdb_function_info, output C.duckdb_data_chunk) {
+	duckdbext.TableDispatch(
+		duckdb.FunctionInfo{Ptr: unsafe.Pointer(info)},
+		duckdb.DataChunk{Ptr: unsafe.Pointer(output)},
+	)
+}
+
+//export goAggregateInit
+func goAggregateInit(info C.duckdb_function_info, state C.duckdb_aggregate_state) {
+	duckdbext.AggregateInit(duckdb.FunctionInfo{Ptr: unsafe.Pointer(info)}, unsafe.Pointer(state))
+}
+
+//export goAggregateUpdate
+func goAggregateUpdate(info C.duckdb_function_info, input C.duckdb_data_chunk, states *C.duckdb_aggregate_state) {
+	duckdbext.AggregateUpdate(
+		duckdb.FunctionInfo{Ptr: unsafe.Pointer(info)},
+		duckdb.DataChunk{Ptr: unsafe.Pointer(input)},
+		unsafe.Pointer(states),
+	)
+}
+
+//export goAggregateCombine
+func goAggregateCombine(info C.duckdb_function_info, source, target *C.duckdb_aggregate_state, count C.idx_t) {
+	duckdbext.AggregateCombine(
+		duckdb.FunctionInfo{Ptr: unsafe.Pointer(info)},
+		unsafe.Pointer(source),
+		unsafe.Pointer(target),
+		uint64(count),
+	)
+}
+
+//export goAggregateFinalize
+func goAggregateFinalize(info C.duckdb_function_info, source *C.duckdb_aggregate_state, result C.duckdb_vector, count, offset C.idx_t) {
+	duckdbext.AggregateFinalize(
+		duckdb.FunctionInfo{Ptr: unsafe.Pointer(info)},
+		unsafe.Pointer(source),
+		duckdb.Vector{Ptr: unsafe.Pointer(result)},
+		uint64(count),
+		uint64(offset),
+	)
+}
+
+//export goAggregateDestroy
+func goAggregateDestroy(states *C.duckdb_aggregate_state, count C.idx_t) {
+	duckdbext.AggregateDestroy(unsafe.Pointer(states), uint64(count))
+}
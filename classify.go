@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// classifyPrompt is the instruction of ai_classify: one label per line, so labels may contain
+// commas.
+func classifyPrompt(labels []string) string {
+	return "Classify the text. Answer with exactly one of these labels and nothing else:\n- " + strings.Join(labels, "\n- ")
+}
+
+// filterPrompt is the instruction of ai_filter for question.
+func filterPrompt(question string) string {
+	return question + "\n\nAnswer with yes or no and nothing else."
+}
+
+// checkLabels rejects label lists ai_classify cannot tell apart in an answer.
+func checkLabels(labels []string) error {
+	if len(labels) == 0 {
+		return errors.New("labels must not be empty")
+	}
+	for i, l := range labels {
+		if strings.TrimSpace(l) == "" {
+			return errors.New("labels must not be blank")
+		}
+		if strings.ContainsRune(l, '\n') {
+			return fmt.Errorf("label %q spans lines", l)
+		}
+		if containsFold(labels[:i], l) {
+			return fmt.Errorf("duplicate label %q", l)
+		}
+	}
+	return nil
+}
+
+// normalizeAnswer lowers answer and strips what models put around a bare word: whitespace,
+// quotes, emphasis and trailing punctuation.
+func normalizeAnswer(answer string) string {
+	return strings.ToLower(strings.TrimFunc(answer, func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune("\"'`*_.,;:!?()[]", r)
+	}))
+}
+
+// parseLabel maps answer to one of labels, ignoring case and punctuation. An answer that is
+// not a label itself is accepted when it names exactly one label, or one label that contains
+// all others it names, e.g. "It is a bug report." for bug and bug report.
+func parseLabel(labels []string, answer string) (string, error) {
+	a := normalizeAnswer(answer)
+	for _, l := range labels {
+		if normalizeAnswer(l) == a {
+			return l, nil
+		}
+	}
+
+	var found []string
+	for _, l := range labels {
+		if containsWord(a, normalizeAnswer(l)) {
+			found = append(found, l)
+		}
+	}
+	for _, l := range found {
+		all := true
+		for _, o := range found {
+			all = all && strings.Contains(strings.ToLower(l), strings.ToLower(o))
+		}
+		if all {
+			return l, nil
+		}
+	}
+
+	if len(found) > 1 {
+		return "", fmt.Errorf("names several labels, answer with one of %s", strings.Join(labels, ", "))
+	}
+	return "", fmt.Errorf("not one of %s", strings.Join(labels, ", "))
+}
+
+// containsWord reports whether s contains word on word boundaries.
+func containsWord(s, word string) bool {
+	if word == "" {
+		return false
+	}
+	isWord := func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }
+	for from := 0; ; {
+		i := strings.Index(s[from:], word)
+		if i < 0 {
+			return false
+		}
+		start, end := from+i, from+i+len(word)
+		before, _ := utf8.DecodeLastRuneInString(s[:start])
+		after, _ := utf8.DecodeRuneInString(s[end:])
+		if !isWord(before) && !isWord(after) {
+			return true
+		}
+		from = end
+	}
+}
+
+// parseYesNo reads the answer of ai_filter from its first word: yes or true, no or false.
+func parseYesNo(answer string) (bool, error) {
+	first := strings.FieldsFunc(strings.ToLower(answer), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	if len(first) > 0 {
+		switch first[0] {
+		case "yes", "true":
+			return true, nil
+		case "no", "false":
+			return false, nil
+		}
+	}
+	return false, errors.New("expected yes or no")
+}
+
+// confidence is the probability of an answer with log probability logprob, nil when the
+// provider did not report one.
+func confidence(logprob *float64) *float64 {
+	if logprob == nil {
+		return nil
+	}
+	p := math.Min(1, math.Exp(*logprob))
+	return &p
+}
@@ -0,0 +1,61 @@
+// Command fakeanthropic serves an anthropictest.Server until it is interrupted, for the
+// end-to-end tests of the Makefile. It writes the value for ANTHROPIC_BASE_URL to -url-file
+// and, on exit, the request counts to stderr.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/liushuangls/go-anthropic/v2"
+	"github.com/mlafeldt/quack-go/anthropictest"
+)
+
+func main() {
+	urlFile := flag.String("url-file", "", "write the base URL to this file (default: stdout)")
+	latency := flag.Duration("latency", 0, "delay of every response")
+	failNext := flag.Int("fail-next", 0, "fail the first n requests")
+	failStatus := flag.Int("fail-status", 529, "HTTP status of -fail-next")
+	failType := flag.String("fail-type", "overloaded_error", "error type of -fail-next")
+	batchPolls := flag.Int("batch-polls", 1, "retrievals a batch reports in_progress")
+	batchStatus := flag.String("batch-status", "ended", "status after -batch-polls: ended, canceling or in_progress")
+	erroredEvery := flag.Int("errored-every", 0, "fail about one in n batch items (by custom_id)")
+	flag.Parse()
+
+	srv := anthropictest.NewServer()
+	defer srv.Close()
+
+	srv.SetLatency(*latency)
+	if *failNext > 0 {
+		srv.FailNext(*failNext, *failStatus, *failType)
+	}
+	srv.SetBatchProcessing(*batchPolls, anthropic.ProcessingStatus(*batchStatus))
+	if n := uint32(*erroredEvery); n > 0 {
+		srv.SetBatchResult(func(customID string) anthropic.ResultType {
+			h := fnv.New32a()
+			h.Write([]byte(customID))
+			if h.Sum32()%n == 0 {
+				return anthropic.ResultTypeErrored
+			}
+			return anthropic.ResultTypeSucceeded
+		})
+	}
+
+	if *urlFile == "" {
+		fmt.Println(srv.BaseURL())
+	} else if err := os.WriteFile(*urlFile, []byte(srv.BaseURL()+"\n"), 0o644); err != nil {
+		fmt.Fprintln(os.Stderr, "fakeanthropic:", err)
+		os.Exit(1)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+
+	fmt.Fprintf(os.Stderr, "fakeanthropic: %d messages, %d batches, %d batch polls\n",
+		srv.MessageCount(), srv.BatchCount(), srv.RetrieveCount())
+}
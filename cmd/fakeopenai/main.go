@@ -0,0 +1,43 @@
+// Command fakeopenai serves an openaitest.Server until it is interrupted, for the end-to-end
+// tests of the Makefile. It writes the value for OPENAI_BASE_URL to -url-file and, on exit,
+// the number of answered requests to stderr.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/mlafeldt/quack-go/openaitest"
+)
+
+func main() {
+	urlFile := flag.String("url-file", "", "write the base URL to this file (default: stdout)")
+	apiKey := flag.String("api-key", "", "bearer key requests must carry (default: none)")
+	failNext := flag.Int("fail-next", 0, "fail the first n requests")
+	failStatus := flag.Int("fail-status", 429, "HTTP status of -fail-next")
+	failType := flag.String("fail-type", "rate_limit_exceeded", "error type of -fail-next")
+	flag.Parse()
+
+	srv := openaitest.NewServer(*apiKey)
+	defer srv.Close()
+
+	if *failNext > 0 {
+		srv.FailNext(*failNext, *failStatus, *failType)
+	}
+
+	if *urlFile == "" {
+		fmt.Println(srv.BaseURL())
+	} else if err := os.WriteFile(*urlFile, []byte(srv.BaseURL()+"\n"), 0o644); err != nil {
+		fmt.Fprintln(os.Stderr, "fakeopenai:", err)
+		os.Exit(1)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+
+	fmt.Fprintf(os.Stderr, "fakeopenai: %d messages\n", srv.MessageCount())
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// dispatchConfig is everything that shapes how calls are dispatched. The defaults come from
+// the environment; sessions override fields with quackai_set. It is comparable so that equal
+// configurations share one dispatchSet, and with it the fused cache.
+type dispatchConfig struct {
+	Mode   string // single, fused, batch or "" (batch if supported, else single)
+	RPS    int    // requests per second to the provider and key, 0 is unlimited
+	APIKey string // from the session's secret; empty uses the environment
+	Cache  bool   // answer from and add to the response cache of QUACK_CACHE
+
+	callParams
+
+	Retries        int
+	RetryBackoffMS int
+
+	FuseDelayMS   int
+	FuseGraceMS   int
+	FusedMulti    bool
+	FusedMaxTexts int
+	FusedBatchMS  int
+}
+
+// callParams override the provider defaults of every request of a dispatchSet.
+type callParams struct {
+	Model          string // "" keeps the provider default
+	MaxTokens      int    // 0 keeps the provider default
+	Temperature    float64
+	HasTemperature bool   // unset keeps the provider default
+	System         string // "" keeps the provider's system prompt
+	PromptRef      string // library prompt of the query, e.g. sentiment@2
+	Examples       *exampleSet
+
+	answerFormat
+}
+
+// answerFormat is what a typed function such as ai_extract or ai_classify asks of every
+// answer; text functions leave it zero.
+type answerFormat struct {
+	Schema   string // JSON schema of structured answers, see CompletionRequest.Schema
+	Logprobs bool   // report the probability of answers, see CompletionRequest.Logprobs
+
+	// Unfused sends single requests in fused mode, for prompts that span lines or may
+	// contain the separator, and answers that are parsed rather than split.
+	Unfused bool
+}
+
+// fusable reports whether requests with p can be fused: a fused request asks one prompt of
+// many texts, which leaves no place for examples, a schema or per-answer probabilities.
+func (p callParams) fusable() bool {
+	return p.Examples == nil && p.answerFormat == (answerFormat{})
+}
+
+// apply sets the parameters req leaves unset.
+func (p callParams) apply(req *CompletionRequest) {
+	if req.Model == "" {
+		req.Model = p.Model
+	}
+	if req.MaxTokens == 0 {
+		req.MaxTokens = p.MaxTokens
+	}
+	if req.System == "" {
+		req.System = p.System
+	}
+	if req.PromptRef == "" {
+		req.PromptRef = p.PromptRef
+	}
+	// fused requests carry their texts in the prompt and go without examples or answer format
+	if req.Text != "" {
+		if req.Examples == nil && p.Examples != nil {
+			req.Examples = p.Examples.examples
+		}
+		if req.Schema == "" {
+			req.Schema = p.Schema
+		}
+		req.Logprobs = req.Logprobs || p.Logprobs
+	}
+	if req.Temperature == nil && p.HasTemperature {
+		t := p.Temperature
+		req.Temperature = &t
+	}
+}
+
+// exampleSet is a list of few-shot examples. Equal lists are interned to one exampleSet, so
+// callParams stay comparable and queries with the same examples share a dispatchSet.
+type exampleSet struct {
+	examples []Example
+}
+
+var (
+	exampleSetsMu sync.Mutex
+	exampleSets   = map[string]*exampleSet{}
+)
+
+// internExamples returns the exampleSet of examples, nil for none.
+func internExamples(examples []Example) *exampleSet {
+	if len(examples) == 0 {
+		return nil
+	}
+	key := examplesKey(examples)
+
+	exampleSetsMu.Lock()
+	defer exampleSetsMu.Unlock()
+
+	if es, ok := exampleSets[key]; ok {
+		return es
+	}
+	es := &exampleSet{examples: examples}
+	exampleSets[key] = es
+	return es
+}
+
+// examplesKey identifies examples in ids and cache keys; "" for none.
+func examplesKey(examples []Example) string {
+	if len(examples) == 0 {
+		return ""
+	}
+	h := sha1.New()
+	for _, ex := range examples {
+		h.Write([]byte(ex.Input + "\x00" + ex.Output + "\x00"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// dispatchConfigFromEnv reads QUACK_LLM_MODE, QUACK_LLM_MODEL, QUACK_LLM_MAX_TOKENS,
+// QUACK_LLM_TEMPERATURE, QUACK_LLM_SYSTEM, QUACK_LLM_RPS (or
+// QUACK_FUSED_RPS), QUACK_LLM_RETRIES, QUACK_LLM_RETRY_BACKOFF_MS, QUACK_CACHE and the
+// QUACK_FUSE* knobs of the fused dispatcher.
+func dispatchConfigFromEnv() dispatchConfig {
+	cfg := dispatchConfig{
+		Mode:           os.Getenv("QUACK_LLM_MODE"),
+		Cache:          os.Getenv("QUACK_CACHE") != "",
+		RetryBackoffMS: 50,
+		FuseDelayMS:    10,
+		FusedMulti:     os.Getenv("QUACK_FUSED_MULTI") == "1",
+		FusedMaxTexts:  16,
+		FusedBatchMS:   5,
+	}
+
+	cfg.Model = os.Getenv("QUACK_LLM_MODEL")
+	cfg.System = os.Getenv("QUACK_LLM_SYSTEM")
+	if v := strings.TrimSpace(os.Getenv("QUACK_LLM_TEMPERATURE")); v != "" {
+		if t, err := strconv.ParseFloat(v, 64); err == nil && t >= 0 {
+			cfg.Temperature, cfg.HasTemperature = t, true
+		}
+	}
+	if n, ok := envInt("QUACK_LLM_MAX_TOKENS"); ok && n > 0 {
+		cfg.MaxTokens = n
+	}
+	if n, ok := envInt("QUACK_LLM_RPS"); ok && n > 0 {
+		cfg.RPS = n
+	} else if n, ok := envInt("QUACK_FUSED_RPS"); ok && n > 0 {
+		cfg.RPS = n
+	}
+	if n, ok := envInt("QUACK_LLM_RETRIES"); ok && n > 0 {
+		cfg.Retries = n
+	}
+	if ms, ok := envInt("QUACK_LLM_RETRY_BACKOFF_MS"); ok && ms >= 0 {
+		cfg.RetryBackoffMS = ms
+	}
+	if ms, ok := envInt("QUACK_FUSE_DELAY_MS"); ok && ms >= 0 {
+		cfg.FuseDelayMS = ms
+	}
+	if ms, ok := envInt("QUACK_FUSE_GRACE_MS"); ok && ms >= 0 {
+		cfg.FuseGraceMS = ms
+	}
+	if n, ok := envInt("QUACK_FUSED_MAX_TEXTS"); ok && n > 0 {
+		cfg.FusedMaxTexts = n
+	}
+	if ms, ok := envInt("QUACK_FUSED_BATCH_MS"); ok && ms >= 0 {
+		cfg.FusedBatchMS = ms
+	}
+
+	return cfg
+}
+
+// newDispatchSet wires p into the path for cfg.Mode.
+func newDispatchSet(cfg dispatchConfig, p Provider) (*dispatchSet, error) {
+	mode := cfg.Mode
+	if mode == "" && !p.Capabilities().Has(CapBatch) {
+		mode = "single"
+	}
+	if mode == "fused" && !cfg.fusable() {
+		mode = "single"
+	}
+
+	rp := p
+	if cfg.RPS > 0 {
+		rp = &rateLimitedProvider{Provider: rp, limiter: sharedLimiter(p, cfg)}
+	}
+	if cfg.Retries > 0 {
+		rp = &retryProvider{Provider: rp, retries: cfg.Retries, backoff: time.Duration(cfg.RetryBackoffMS) * time.Millisecond}
+	}
+	// the cache sits outside retries and the rate limit, so its answers cost neither, and
+	// inside the parameters, so they are part of its keys. Fused dispatchers cache answers
+	// per text and prompt instead of whole fused requests.
+	fp := rp
+	var (
+		cache *responseCache
+		pairs *pairCache
+	)
+	if cfg.Cache {
+		var err error
+		if cache, err = responseCacheFromEnv(); err != nil {
+			return nil, err
+		}
+		if cache == nil {
+			return nil, errors.New("cache: QUACK_CACHE is not set")
+		}
+		rp = &cachedProvider{Provider: rp, cache: cache, scope: cacheScope(p)}
+		pairs = &pairCache{cache: cache, scope: cacheScope(p), params: cfg.callParams}
+	}
+	if cfg.callParams != (callParams{}) {
+		rp = &paramsProvider{Provider: rp, params: cfg.callParams}
+		fp = &paramsProvider{Provider: fp, params: cfg.callParams}
+	}
+	newFused := func() *FusedDispatcher {
+		fd := NewFusedDispatcher(fp, ";", cfg)
+		fd.store = pairs
+		return fd
+	}
+
+	ds := &dispatchSet{directProvider: rp}
+
+	switch mode {
+	case "single":
+		ds.singleProvider = rp
+		ds.multiDispatcher = newFused()
+
+	case "fused":
+		ds.fusedDispatcher = newFused()
+		ds.multiDispatcher = ds.fusedDispatcher
+
+	default: // "batch"
+		bp, ok := p.(BatchProvider)
+		if !ok || !p.Capabilities().Has(CapBatch) {
+			return nil, fmt.Errorf("provider %s does not support batch mode", p.Name())
+		}
+		if cache != nil {
+			bp = &cachedProvider{Provider: p, batch: bp, cache: cache, scope: cacheScope(p)}
+		}
+		ds.dispatcher = NewLLMDispatcher(bp)
+		ds.dispatcher.params = cfg.callParams
+		ds.multiDispatcher = newFused()
+	}
+
+	return ds, nil
+}
+
+// maxDispatchSets bounds dispatchSets. Every distinct combination of session settings and
+// call options has a configuration of its own, so the least recently used sets are dropped;
+// queries that bound one keep using it.
+const maxDispatchSets = 64
+
+var (
+	dispatchMu sync.Mutex
+
+	// dispatchSets holds the dispatchSets of the configurations in use, at most
+	// maxDispatchSets; dispatchUsed orders them by last use.
+	dispatchSets = map[dispatchConfig]*dispatchSet{}
+	dispatchUsed = map[dispatchConfig]uint64{}
+	dispatchTick uint64
+
+	// defaultConfig is the configuration of defaultDispatch, which is never dropped.
+	defaultConfig dispatchConfig
+
+	// providers holds the provider per mode, since anthropic uses a different client for
+	// batch mode, and per API key, for as long as a cached dispatchSet uses it.
+	providers = map[providerKey]Provider{}
+
+	// limiters holds the rate limiter per provider, API key and rate, shared by all
+	// cached dispatchSets that send to them whatever their call options.
+	limiters = map[limiterKey]*rate.Limiter{}
+)
+
+type providerKey struct {
+	mode   string
+	apiKey string
+}
+
+type limiterKey struct {
+	provider string
+	apiKey   string
+	rps      int
+}
+
+// sharedLimiter returns the limiter of cfg.RPS requests per second to p with cfg.APIKey.
+// The caller holds dispatchMu.
+func sharedLimiter(p Provider, cfg dispatchConfig) *rate.Limiter {
+	k := limiterKey{provider: p.Name(), apiKey: cfg.APIKey, rps: cfg.RPS}
+	l, ok := limiters[k]
+	if !ok {
+		l = rate.NewLimiter(rate.Limit(cfg.RPS), 1)
+		limiters[k] = l
+	}
+	return l
+}
+
+// addDispatchSet stores ds for cfg, dropping the least recently used set when full. The
+// caller holds dispatchMu.
+func addDispatchSet(cfg dispatchConfig, ds *dispatchSet) {
+	dispatchSets[cfg] = ds
+	touchDispatchSet(cfg)
+	if len(dispatchSets) <= maxDispatchSets {
+		return
+	}
+
+	var (
+		oldest dispatchConfig
+		min    uint64
+		found  bool
+	)
+	for c, t := range dispatchUsed {
+		if c != defaultConfig && (!found || t < min) {
+			oldest, min, found = c, t, true
+		}
+	}
+	delete(dispatchSets, oldest)
+	delete(dispatchUsed, oldest)
+	pruneProviders()
+}
+
+// pruneProviders drops the providers and limiters that no cached dispatchSet uses, so
+// they go with the last set of their API key or rate. The caller holds dispatchMu.
+func pruneProviders() {
+	usedProviders := map[providerKey]bool{}
+	usedLimiters := map[limiterKey]bool{}
+	for cfg := range dispatchSets {
+		pk := providerKey{mode: cfg.Mode, apiKey: cfg.APIKey}
+		usedProviders[pk] = true
+		if p, ok := providers[pk]; ok {
+			usedLimiters[limiterKey{provider: p.Name(), apiKey: cfg.APIKey, rps: cfg.RPS}] = true
+		}
+	}
+
+	for pk := range providers {
+		if !usedProviders[pk] {
+			delete(providers, pk)
+		}
+	}
+	for lk := range limiters {
+		if !usedLimiters[lk] {
+			delete(limiters, lk)
+		}
+	}
+}
+
+// forgetAPIKey drops the cached dispatchSets of apiKey along with their providers and
+// limiters. Queries that bound one keep using it.
+func forgetAPIKey(apiKey string) {
+	dispatchMu.Lock()
+	defer dispatchMu.Unlock()
+
+	for cfg := range dispatchSets {
+		if cfg.APIKey == apiKey {
+			delete(dispatchSets, cfg)
+			delete(dispatchUsed, cfg)
+		}
+	}
+	pruneProviders()
+}
+
+// touchDispatchSet marks the set of cfg as used. The caller holds dispatchMu.
+func touchDispatchSet(cfg dispatchConfig) {
+	dispatchTick++
+	dispatchUsed[cfg] = dispatchTick
+}
+
+// dispatchFor returns the dispatchSet for cfg, building it and its provider on first use.
+func dispatchFor(cfg dispatchConfig) (*dispatchSet, error) {
+	dispatchMu.Lock()
+	defer dispatchMu.Unlock()
+
+	if ds, ok := dispatchSets[cfg]; ok {
+		touchDispatchSet(cfg)
+		return ds, nil
+	}
+
+	pk := providerKey{mode: cfg.Mode, apiKey: cfg.APIKey}
+	p, ok := providers[pk]
+	if !ok {
+		var err error
+		if p, err = newProviderFromEnv(cfg.Mode, cfg.APIKey); err != nil {
+			return nil, err
+		}
+		providers[pk] = p
+	}
+
+	ds, err := newDispatchSet(cfg, p)
+	if err != nil {
+		return nil, err
+	}
+	addDispatchSet(cfg, ds)
+	return ds, nil
+}
+
+// paramsProvider applies call parameters to requests that leave them at the provider default.
+type paramsProvider struct {
+	Provider
+	params callParams
+}
+
+func (p *paramsProvider) Complete(ctx context.Context, req CompletionRequest) (Completion, error) {
+	p.params.apply(&req)
+	return p.Provider.Complete(ctx, req)
+}
+
+// rateLimitedProvider waits for its limiter before every request, including retries.
+type rateLimitedProvider struct {
+	Provider
+	limiter *rate.Limiter
+}
+
+func (r *rateLimitedProvider) Complete(ctx context.Context, req CompletionRequest) (Completion, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return Completion{}, err
+	}
+	return r.Provider.Complete(ctx, req)
+}
@@ -5,8 +5,6 @@ import (
 	"fmt"
 	"sync"
 	"time"
-
-	"github.com/liushuangls/go-anthropic/v2"
 )
 
 type llmJob struct {
@@ -17,7 +15,7 @@ type llmJob struct {
 
 type batchWaiter struct {
 	done    chan struct{}
-	results map[string]string // custom_id -> answer
+	results map[string]Completion // customID(text, prompt) -> answer
 	err     error
 }
 
@@ -29,16 +27,18 @@ type LLMDispatcher struct {
 
 	flushScheduled bool
 
-	client *AnthropicBatchClient
+	client BatchProvider
 
 	flushDelay   time.Duration
 	maxBatchSize int
 
 	pollEvery   time.Duration
 	pollTimeout time.Duration
+
+	params callParams // applied to every request
 }
 
-func NewLLMDispatcher(client *AnthropicBatchClient) *LLMDispatcher {
+func NewLLMDispatcher(client BatchProvider) *LLMDispatcher {
 	return &LLMDispatcher{
 		client:         client,
 		flushDelay:     5 * time.Millisecond,
@@ -49,7 +49,9 @@ func NewLLMDispatcher(client *AnthropicBatchClient) *LLMDispatcher {
 	}
 }
 
-func (d *LLMDispatcher) Submit(ctx context.Context, jobs []llmJob) (map[string]string, error) {
+// Submit batches jobs with those of concurrent callers and returns the answers keyed by
+// customID(text, prompt). Failed items have Failure set; missing keys were never answered.
+func (d *LLMDispatcher) Submit(ctx context.Context, jobs []llmJob) (map[string]Completion, error) {
 	w := &batchWaiter{done: make(chan struct{})}
 
 	d.mu.Lock()
@@ -84,9 +86,10 @@ func (d *LLMDispatcher) flush() {
 	pollEvery := d.pollEvery
 	pollTimeout := d.pollTimeout
 	maxBatchSize := d.maxBatchSize
+	params := d.params
 	d.mu.Unlock()
 
-	wakeAll := func(results map[string]string, err error) {
+	wakeAll := func(results map[string]Completion, err error) {
 		for _, w := range waiters {
 			w.results = results
 			w.err = err
@@ -99,16 +102,16 @@ func (d *LLMDispatcher) flush() {
 	}
 
 	if len(jobs) == 0 {
-		wakeAll(map[string]string{}, nil)
+		wakeAll(map[string]Completion{}, nil)
 		return
 	}
 
 	if client == nil {
-		wakeAll(nil, fmt.Errorf("anthropic client is nil"))
+		wakeAll(nil, fmt.Errorf("batch provider is nil"))
 		return
 	}
 
-	allResults := make(map[string]string, len(jobs))
+	allResults := make(map[string]Completion, len(jobs))
 	var firstErr error
 
 	for start := 0; start < len(jobs); start += maxBatchSize {
@@ -118,21 +121,28 @@ func (d *LLMDispatcher) flush() {
 		}
 		chunk := jobs[start:end]
 
-		reqs := make([]anthropic.InnerRequests, 0, len(chunk))
+		reqs := make([]CompletionRequest, 0, len(chunk))
 		for _, j := range chunk {
-			cid := makeCustomID(j.row, j.text, j.prompt)
-			reqs = append(reqs, buildAnthropicInnerRequest(cid, j.text, j.prompt, client.maxTokens))
+			req := CompletionRequest{Text: j.text, Prompt: j.prompt}
+			params.apply(&req)
+			req.CustomID = makeCustomID(j.row, req)
+			reqs = append(reqs, req)
 		}
 
 		runCtx, cancel := context.WithTimeout(context.Background(), pollTimeout+10*time.Second)
-		res, err := client.RunMessageBatch(runCtx, reqs, pollEvery, pollTimeout)
+		res, err := client.RunBatch(runCtx, reqs, pollEvery, pollTimeout)
 		cancel()
 
 		if err != nil && firstErr == nil {
 			firstErr = err
 		}
-		for k, v := range res {
-			allResults[k] = v
+		// the batch answers under the request ids of makeCustomID, which include the row and
+		// call parameters, while callers look answers up by customID(text, prompt); map each
+		// answer back through the request it belongs to
+		for i, j := range chunk {
+			if v, ok := res[reqs[i].CustomID]; ok {
+				allResults[customID(j.text, j.prompt)] = v
+			}
 		}
 	}
 
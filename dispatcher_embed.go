@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type embedWaiter struct {
+	done    chan struct{}
+	vectors map[string][]float32 // text -> vector
+	err     error
+}
+
+// EmbedDispatcher collects the texts of concurrent ai_embed calls for a short delay and
+// embeds them in requests of up to maxBatchSize distinct texts, like LLMDispatcher does for
+// completions.
+type EmbedDispatcher struct {
+	mu sync.Mutex
+
+	pending []string
+	waiters []*embedWaiter
+
+	flushScheduled bool
+
+	embedder Embedder
+	model    string
+
+	flushDelay   time.Duration
+	maxBatchSize int
+	timeout      time.Duration
+}
+
+func NewEmbedDispatcher(e Embedder, model string, maxBatchSize int) *EmbedDispatcher {
+	return &EmbedDispatcher{
+		embedder:     e,
+		model:        model,
+		flushDelay:   5 * time.Millisecond,
+		maxBatchSize: maxBatchSize,
+		timeout:      120 * time.Second,
+	}
+}
+
+// Submit embeds texts together with those of concurrent callers and returns the vectors by
+// text. Texts of a failed request are missing and the first error is returned with the rest.
+func (d *EmbedDispatcher) Submit(ctx context.Context, texts []string) (map[string][]float32, error) {
+	w := &embedWaiter{done: make(chan struct{})}
+
+	d.mu.Lock()
+	d.pending = append(d.pending, texts...)
+	d.waiters = append(d.waiters, w)
+
+	if !d.flushScheduled {
+		d.flushScheduled = true
+		time.AfterFunc(d.flushDelay, func() { d.flush() })
+	}
+	d.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-w.done:
+		return w.vectors, w.err
+	}
+}
+
+func (d *EmbedDispatcher) flush() {
+	d.mu.Lock()
+	d.flushScheduled = false
+
+	texts := d.pending
+	waiters := d.waiters
+	d.pending = nil
+	d.waiters = nil
+	d.mu.Unlock()
+
+	if len(waiters) == 0 {
+		return
+	}
+
+	uniq := make([]string, 0, len(texts))
+	seen := make(map[string]bool, len(texts))
+	for _, t := range texts {
+		if !seen[t] {
+			seen[t] = true
+			uniq = append(uniq, t)
+		}
+	}
+
+	vectors := make(map[string][]float32, len(uniq))
+	var firstErr error
+
+	for start := 0; start < len(uniq); start += d.maxBatchSize {
+		chunk := uniq[start:min(start+d.maxBatchSize, len(uniq))]
+
+		ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+		res, err := d.embedder.Embed(ctx, d.model, chunk)
+		cancel()
+
+		if err == nil && len(res) != len(chunk) {
+			err = fmt.Errorf("%s returned %d embeddings for %d texts", d.embedder.Name(), len(res), len(chunk))
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		for i, t := range chunk {
+			if res[i] != nil {
+				vectors[t] = res[i]
+			}
+		}
+	}
+
+	for _, w := range waiters {
+		w.vectors = vectors
+		w.err = firstErr
+		close(w.done)
+	}
+}
+
+var (
+	embedMu sync.Mutex
+
+	// embedDispatchers holds one EmbedDispatcher per provider, model and API key in use.
+	embedDispatchers = map[embedKey]*EmbedDispatcher{}
+)
+
+type embedKey struct {
+	provider string
+	model    string
+	apiKey   string
+}
+
+// embedDispatcherFor returns the dispatcher of model on the embedding provider of the
+// environment, building it on first use. QUACK_EMBED_BATCH (default 256) limits the texts
+// per request.
+func embedDispatcherFor(provider, model, apiKey string) (*EmbedDispatcher, error) {
+	embedMu.Lock()
+	defer embedMu.Unlock()
+
+	key := embedKey{provider: provider, model: model, apiKey: apiKey}
+	if d, ok := embedDispatchers[key]; ok {
+		return d, nil
+	}
+
+	e, err := newEmbedderFromEnv(provider, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	batch := 256
+	if n, ok := envInt("QUACK_EMBED_BATCH"); ok && n > 0 {
+		batch = n
+	}
+
+	d := NewEmbedDispatcher(e, model, batch)
+	embedDispatchers[key] = d
+	return d, nil
+}
+
+// forgetEmbedKey drops the dispatchers of apiKey.
+func forgetEmbedKey(apiKey string) {
+	embedMu.Lock()
+	defer embedMu.Unlock()
+
+	for k := range embedDispatchers {
+		if k.apiKey == apiKey {
+			delete(embedDispatchers, k)
+		}
+	}
+}
@@ -2,14 +2,15 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
-
-	"golang.org/x/time/rate"
 )
 
 type fusedBatch struct {
@@ -24,8 +25,9 @@ type fusedBatch struct {
 
 	frozen bool
 
-	err  error
-	done chan struct{}
+	attempts int
+	err      error
+	done     chan struct{}
 }
 
 type fusedWorkItem struct {
@@ -43,68 +45,50 @@ type FusedDispatcher struct {
 
 	// cache: text -> prompt -> answer
 	cache map[string]map[string]string
+	// store backs cache with the response cache, nil without one
+	store *pairCache
 
-	client *AnthropicSingleClient
+	client Provider
 	sep    string
 
 	fuseDelay  time.Duration
 	fuseGrace  time.Duration
 	maxWaitCtx time.Duration
 
-	limiter *rate.Limiter // nil => disabled
-
 	debug bool
 
 	multiEnabled   bool
 	multiMaxTexts  int
 	multiBatchWait time.Duration
 	workCh         chan fusedWorkItem
-	startOnce      sync.Once
+
+	// the multi worker runs while there is work, so dispatchers dropped from dispatchSets
+	// leave no goroutine behind
+	workerMu      sync.Mutex
+	workerRunning bool
 }
 
-func NewFusedDispatcher(client *AnthropicSingleClient, sep string) *FusedDispatcher {
+// multiWorkerIdle is how long the multi worker waits for work before it exits.
+const multiWorkerIdle = time.Minute
+
+func NewFusedDispatcher(client Provider, sep string, cfg dispatchConfig) *FusedDispatcher {
 	fd := &FusedDispatcher{
 		batches:    make(map[string]*fusedBatch),
 		inflight:   make(map[string]*fusedBatch),
 		cache:      make(map[string]map[string]string),
 		client:     client,
 		sep:        sep,
-		fuseDelay:  10 * time.Millisecond,
-		fuseGrace:  0 * time.Millisecond,
+		fuseDelay:  time.Duration(cfg.FuseDelayMS) * time.Millisecond,
+		fuseGrace:  time.Duration(cfg.FuseGraceMS) * time.Millisecond,
 		maxWaitCtx: 30 * time.Second,
-		limiter:    nil,
 		debug:      os.Getenv("QUACK_LLM_DEBUG") == "1",
 
-		multiEnabled:   os.Getenv("QUACK_FUSED_MULTI") == "1",
-		multiMaxTexts:  16,
-		multiBatchWait: 5 * time.Millisecond,
+		multiEnabled:   cfg.FusedMulti,
+		multiMaxTexts:  cfg.FusedMaxTexts,
+		multiBatchWait: time.Duration(cfg.FusedBatchMS) * time.Millisecond,
 		workCh:         make(chan fusedWorkItem, 4096),
 	}
 
-	if ms, ok := envInt("QUACK_FUSE_DELAY_MS"); ok && ms >= 0 {
-		fd.fuseDelay = time.Duration(ms) * time.Millisecond
-	}
-	if ms, ok := envInt("QUACK_FUSE_GRACE_MS"); ok && ms >= 0 {
-		fd.fuseGrace = time.Duration(ms) * time.Millisecond
-	}
-
-	if n, ok := envInt("QUACK_FUSED_MAX_TEXTS"); ok && n > 0 {
-		fd.multiMaxTexts = n
-	}
-	if ms, ok := envInt("QUACK_FUSED_BATCH_MS"); ok && ms >= 0 {
-		fd.multiBatchWait = time.Duration(ms) * time.Millisecond
-	}
-
-	// have sometimes to limited call :/
-	if rps, ok := envInt("QUACK_FUSED_RPS"); ok && rps > 0 {
-		fd.limiter = rate.NewLimiter(rate.Limit(rps), 1)
-	}
-
-	// Start worker if enabled
-	if fd.multiEnabled {
-		fd.startOnce.Do(func() { go fd.multiWorker() })
-	}
-
 	return fd
 }
 
@@ -127,14 +111,19 @@ GetResult returns the answer for (text,prompt).
 3. Add to collecting batch
 */
 func (d *FusedDispatcher) GetResult(text, prompt string) (string, error) {
+	o := d.GetOutcome(text, prompt)
+	return o.Value, o.Err
+}
+
+// GetOutcome is GetResult plus whether the answer was cached and how many attempts the
+// fused request took.
+func (d *FusedDispatcher) GetOutcome(text, prompt string) llmOutcome {
 	for {
 		// 1) cache
 		d.mu.Lock()
-		if mp := d.cache[text]; mp != nil {
-			if ans := mp[prompt]; ans != "" {
-				d.mu.Unlock()
-				return ans, nil
-			}
+		if ans, ok := d.cached(text, prompt); ok {
+			d.mu.Unlock()
+			return llmOutcome{Value: ans, Cached: true}
 		}
 
 		// 2) inflight
@@ -148,10 +137,8 @@ func (d *FusedDispatcher) GetResult(text, prompt string) (string, error) {
 			if included {
 				<-in.done
 				in.Lock()
-				ans := in.prompts[prompt]
-				err := in.err
-				in.Unlock()
-				return ans, err
+				defer in.Unlock()
+				return llmOutcome{Value: in.prompts[prompt], Err: in.err, Attempts: in.attempts}
 			}
 
 			<-in.done
@@ -182,10 +169,8 @@ func (d *FusedDispatcher) GetResult(text, prompt string) (string, error) {
 
 			<-b.done
 			b.Lock()
-			ans := b.prompts[prompt]
-			err := b.err
-			b.Unlock()
-			return ans, err
+			defer b.Unlock()
+			return llmOutcome{Value: b.prompts[prompt], Err: b.err, Attempts: b.attempts}
 		}
 		b.Unlock()
 		d.mu.Unlock()
@@ -209,7 +194,7 @@ func (d *FusedDispatcher) flushText(text string) {
 	}
 
 	if d.client == nil {
-		setAll(b, "ERR:client_nil", d.debug, fmt.Errorf("single client is nil"))
+		setAll(b, "ERR:client_nil", d.debug, fmt.Errorf("provider is nil"))
 		d.mu.Lock()
 		delete(d.inflight, text)
 		d.mu.Unlock()
@@ -245,6 +230,7 @@ func (d *FusedDispatcher) flushText(text string) {
 			fusedPrompt: fusedPrompt,
 			b:           b,
 		}
+		d.startMultiWorker()
 		return
 	}
 
@@ -253,19 +239,30 @@ func (d *FusedDispatcher) flushText(text string) {
 	}
 
 	t0 := time.Now()
-	raw, err := d.runSingleFusedRequest(text, fusedPrompt)
+	raw, attempts, err := d.runSingleFusedRequest(text, fusedPrompt)
 	RecordUpstreamRequest(time.Since(t0))
 
+	b.Lock()
+	b.attempts = attempts
+	b.Unlock()
+
 	d.finishSingle(text, b, promptList, raw, err)
 }
 
 func (d *FusedDispatcher) finishSingle(text string, b *fusedBatch, promptList []string, raw string, err error) {
+	d.resolveFused(text, b, promptList, raw, err)
+
+	d.mu.Lock()
+	delete(d.inflight, text)
+	d.mu.Unlock()
+
+	close(b.done)
+}
+
+// resolveFused parses raw into b's answers (or sets b.err) and caches successful answers.
+func (d *FusedDispatcher) resolveFused(text string, b *fusedBatch, promptList []string, raw string, err error) {
 	if err != nil {
 		setAll(b, "ERR:"+err.Error(), d.debug, err)
-		d.mu.Lock()
-		delete(d.inflight, text)
-		d.mu.Unlock()
-		close(b.done)
 		return
 	}
 
@@ -281,13 +278,9 @@ func (d *FusedDispatcher) finishSingle(text string, b *fusedBatch, promptList []
 			b.Unlock()
 		} else {
 			b.Lock()
-			b.err = fmt.Errorf("got %d parts, want %d", len(parts), len(promptList))
+			b.err = withKind("parse_mismatch", fmt.Errorf("got %d parts, want %d", len(parts), len(promptList)))
 			b.Unlock()
 		}
-		d.mu.Lock()
-		delete(d.inflight, text)
-		d.mu.Unlock()
-		close(b.done)
 		return
 	}
 
@@ -299,18 +292,139 @@ func (d *FusedDispatcher) finishSingle(text string, b *fusedBatch, promptList []
 	b.Unlock()
 
 	d.mu.Lock()
+	d.remember(text, b.prompts)
+	d.mu.Unlock()
+}
+
+// cached returns the answer to (text, prompt) from the cache or the store. d.mu must be held.
+func (d *FusedDispatcher) cached(text, prompt string) (string, bool) {
+	if ans := d.cache[text][prompt]; ans != "" {
+		return ans, true
+	}
+	ans, ok := d.store.get(text, prompt)
+	if ok {
+		if d.cache[text] == nil {
+			d.cache[text] = make(map[string]string, 1)
+		}
+		d.cache[text][prompt] = ans
+	}
+	return ans, ok
+}
+
+// remember caches the non-empty answers to text by prompt. d.mu must be held.
+func (d *FusedDispatcher) remember(text string, answers map[string]string) {
 	if d.cache[text] == nil {
-		d.cache[text] = make(map[string]string, len(promptList))
+		d.cache[text] = make(map[string]string, len(answers))
 	}
-	for p, a := range b.prompts {
+	for p, a := range answers {
 		if a != "" {
 			d.cache[text][p] = a
+			d.store.put(text, p, a)
+		}
+	}
+}
+
+/*
+GetResults answers all prompts for text with one fused request, without waiting for
+fuseDelay to collect them. Cached answers are reused; only the rest is requested.
+*/
+func (d *FusedDispatcher) GetResults(text string, prompts []string) (map[string]string, error) {
+	out := make(map[string]string, len(prompts))
+	missing := make([]string, 0, len(prompts))
+
+	d.mu.Lock()
+	for _, p := range prompts {
+		if ans, ok := d.cached(text, p); ok {
+			out[p] = ans
+			continue
+		}
+		if !slices.Contains(missing, p) {
+			missing = append(missing, p)
 		}
 	}
-	delete(d.inflight, text)
 	d.mu.Unlock()
 
-	close(b.done)
+	if len(missing) == 0 {
+		return out, nil
+	}
+	if d.client == nil {
+		return nil, fmt.Errorf("provider is nil")
+	}
+
+	t0 := time.Now()
+	answers, err := d.runNumberedRequest(text, missing)
+	RecordUpstreamRequest(time.Since(t0))
+
+	if err != nil {
+		if !d.debug {
+			return nil, err
+		}
+		answers = make([]string, len(missing))
+		for i := range answers {
+			answers[i] = "ERR:" + err.Error()
+		}
+	}
+
+	got := make(map[string]string, len(missing))
+	for i, p := range missing {
+		got[p], out[p] = answers[i], answers[i]
+	}
+	if err == nil {
+		d.mu.Lock()
+		d.remember(text, got)
+		d.mu.Unlock()
+	}
+	return out, nil
+}
+
+// runNumberedRequest asks all prompts about text in one request. Prompts and answers travel
+// as JSON arrays rather than joined by the separator, so any prompt or answer can be sent.
+func (d *FusedDispatcher) runNumberedRequest(text string, prompts []string) ([]string, error) {
+	instructions, err := json.Marshal(prompts)
+	if err != nil {
+		return nil, err
+	}
+	system := `Return machine-parseable output.`
+	user := fmt.Sprintf(
+		`TEXT:%s
+INSTRUCTIONS_JSON:%s
+Follow each instruction of the JSON array on the text. Return ONLY a JSON array of %d strings: the answers, in the same order.`,
+		text, instructions, len(prompts),
+	)
+
+	reqCtx, cancel := context.WithTimeout(context.Background(), d.maxWaitCtx)
+	defer cancel()
+
+	c, err := d.client.Complete(reqCtx, CompletionRequest{Prompt: system + "\n" + user})
+	if err != nil {
+		return nil, err
+	}
+	return parseNumbered(c.Text, len(prompts))
+}
+
+// parseNumbered reads the JSON array of n answers in raw, which may be wrapped in a code
+// fence. Answers that are numbers or booleans are kept as their JSON text.
+func parseNumbered(raw string, n int) ([]string, error) {
+	i, j := strings.Index(raw, "["), strings.LastIndex(raw, "]")
+	if i < 0 || j < i {
+		return nil, withKind("parse_mismatch", errors.New("the answer is not a JSON array"))
+	}
+
+	var items []json.RawMessage
+	if err := json.Unmarshal([]byte(raw[i:j+1]), &items); err != nil {
+		return nil, withKind("parse_mismatch", fmt.Errorf("the answer is not a JSON array: %w", err))
+	}
+	if len(items) != n {
+		return nil, withKind("parse_mismatch", fmt.Errorf("got %d answers, want %d", len(items), n))
+	}
+
+	answers := make([]string, n)
+	for k, item := range items {
+		if err := json.Unmarshal(item, &answers[k]); err != nil {
+			answers[k] = strings.TrimSpace(string(item))
+		}
+	}
+	return answers, nil
 }
 
 func setAll(b *fusedBatch, msg string, debug bool, err error) {
@@ -326,13 +440,8 @@ func setAll(b *fusedBatch, msg string, debug bool, err error) {
 	}
 }
 
-func (d *FusedDispatcher) runSingleFusedRequest(text, fusedPrompt string) (string, error) {
-	if d.limiter != nil {
-		if err := d.limiter.Wait(context.Background()); err != nil {
-			return "", err
-		}
-	}
-
+// runSingleFusedRequest returns the raw fused answer and the number of attempts it took.
+func (d *FusedDispatcher) runSingleFusedRequest(text, fusedPrompt string) (string, int, error) {
 	// Keep this short to reduce token overhead
 	system := `Return machine-parseable output.`
 	user := fmt.Sprintf(
@@ -345,13 +454,42 @@ Return answers ONLY, in order, separated by "%s". No newlines.`,
 	reqCtx, cancel := context.WithTimeout(context.Background(), d.maxWaitCtx)
 	defer cancel()
 
-	return d.client.Run(reqCtx, "", system+"\n"+user)
+	reqCtx, attempts := withAttemptCounter(reqCtx)
+	c, err := d.client.Complete(reqCtx, CompletionRequest{Prompt: system + "\n" + user})
+	return c.Text, attemptsMade(attempts), err
+}
+
+// startMultiWorker starts the multi worker unless it is running.
+func (d *FusedDispatcher) startMultiWorker() {
+	d.workerMu.Lock()
+	defer d.workerMu.Unlock()
+
+	if !d.workerRunning {
+		d.workerRunning = true
+		go d.multiWorker()
+	}
 }
 
 func (d *FusedDispatcher) multiWorker() {
+	idle := time.NewTimer(multiWorkerIdle)
+	defer idle.Stop()
+
 	for {
-		// block until we have at least one job
-		first := <-d.workCh
+		// block until we have at least one job, or exit when idle
+		var first fusedWorkItem
+		select {
+		case first = <-d.workCh:
+		case <-idle.C:
+			d.workerMu.Lock()
+			if len(d.workCh) > 0 {
+				d.workerMu.Unlock()
+				idle.Reset(multiWorkerIdle)
+				continue
+			}
+			d.workerRunning = false
+			d.workerMu.Unlock()
+			return
+		}
 		batch := make([]fusedWorkItem, 0, d.multiMaxTexts)
 		batch = append(batch, first)
 
@@ -369,6 +507,14 @@ func (d *FusedDispatcher) multiWorker() {
 		_ = deadline.Stop()
 
 		d.runMultiBatch(batch)
+
+		if !idle.Stop() {
+			select {
+			case <-idle.C:
+			default:
+			}
+		}
+		idle.Reset(multiWorkerIdle)
 	}
 }
 
@@ -383,8 +529,11 @@ func (d *FusedDispatcher) runMultiBatch(items []fusedWorkItem) {
 			// fallback
 			for _, x := range items {
 				t0 := time.Now()
-				raw, err := d.runSingleFusedRequest(x.text, x.fusedPrompt)
+				raw, attempts, err := d.runSingleFusedRequest(x.text, x.fusedPrompt)
 				RecordUpstreamRequest(time.Since(t0))
+				x.b.Lock()
+				x.b.attempts = attempts
+				x.b.Unlock()
 				d.finishSingle(x.text, x.b, x.promptList, raw, err)
 			}
 			return
@@ -422,10 +571,20 @@ func (d *FusedDispatcher) runMultiBatch(items []fusedWorkItem) {
 	reqCtx, cancel := context.WithTimeout(context.Background(), d.maxWaitCtx)
 	defer cancel()
 
+	reqCtx, counter := withAttemptCounter(reqCtx)
+
 	t0 := time.Now()
-	raw, err := d.client.Run(reqCtx, "", sb.String())
+	c, err := d.client.Complete(reqCtx, CompletionRequest{Prompt: sb.String()})
+	raw := c.Text
 	RecordUpstreamRequest(time.Since(t0))
 
+	attempts := attemptsMade(counter)
+	for _, it := range items {
+		it.b.Lock()
+		it.b.attempts = attempts
+		it.b.Unlock()
+	}
+
 	if err != nil || strings.TrimSpace(raw) == "" {
 		// fail
 		for _, it := range items {
@@ -451,7 +610,7 @@ func (d *FusedDispatcher) runMultiBatch(items []fusedWorkItem) {
 				it.b.Unlock()
 			} else {
 				it.b.Lock()
-				it.b.err = fmt.Errorf("got %d lines, want %d", len(lines), len(items))
+				it.b.err = withKind("parse_mismatch", fmt.Errorf("got %d lines, want %d", len(lines), len(items)))
 				it.b.Unlock()
 			}
 			d.mu.Lock()
@@ -476,7 +635,7 @@ func (d *FusedDispatcher) runMultiBatch(items []fusedWorkItem) {
 				it.b.Unlock()
 			} else {
 				it.b.Lock()
-				it.b.err = fmt.Errorf("mgot %d, want %d", len(parts), len(it.promptList))
+				it.b.err = withKind("parse_mismatch", fmt.Errorf("got %d, want %d", len(parts), len(it.promptList)))
 				it.b.Unlock()
 			}
 			d.mu.Lock()
@@ -495,14 +654,7 @@ func (d *FusedDispatcher) runMultiBatch(items []fusedWorkItem) {
 
 		// cache + cleanup
 		d.mu.Lock()
-		if d.cache[it.text] == nil {
-			d.cache[it.text] = make(map[string]string, len(it.promptList))
-		}
-		for p, a := range it.b.prompts {
-			if a != "" {
-				d.cache[it.text][p] = a
-			}
-		}
+		d.remember(it.text, it.b.prompts)
 		delete(d.inflight, it.text)
 		d.mu.Unlock()
 
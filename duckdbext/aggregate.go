@@ -0,0 +1,226 @@
+package duckdbext
+
+/*
+#include <duckdb_extension.h>
+#include <stdint.h>
+#include <stdlib.h>
+
+extern duckdb_ext_api_v1 duckdb_ext_api;
+
+idx_t aggregateStateSizeWrapper(duckdb_function_info info);
+void aggregateInitWrapper(duckdb_function_info info, duckdb_aggregate_state state);
+void aggregateUpdateWrapper(duckdb_function_info info, duckdb_data_chunk input, duckdb_aggregate_state *states);
+void aggregateCombineWrapper(duckdb_function_info info, duckdb_aggregate_state *source, duckdb_aggregate_state *target, idx_t count);
+void aggregateFinalizeWrapper(duckdb_function_info info, duckdb_aggregate_state *source, duckdb_vector result, idx_t count, idx_t offset);
+void aggregateDestroyWrapper(duckdb_aggregate_state *states, idx_t count);
+void extraInfoDestroy(void *ptr);
+
+// duckdb-go-bindings has no aggregate functions yet; call the extension API directly.
+
+static duckdb_aggregate_function aggregate_create(const char *name) {
+	duckdb_aggregate_function f = duckdb_create_aggregate_function();
+	duckdb_aggregate_function_set_name(f, name);
+	duckdb_aggregate_function_set_functions(f, aggregateStateSizeWrapper, aggregateInitWrapper,
+		aggregateUpdateWrapper, aggregateCombineWrapper, aggregateFinalizeWrapper);
+	duckdb_aggregate_function_set_destructor(f, aggregateDestroyWrapper);
+	return f;
+}
+
+static void aggregate_destroy(duckdb_aggregate_function f) { duckdb_destroy_aggregate_function(&f); }
+
+static void aggregate_add_parameter(duckdb_aggregate_function f, duckdb_logical_type t) {
+	duckdb_aggregate_function_add_parameter(f, t);
+}
+
+static void aggregate_set_return_type(duckdb_aggregate_function f, duckdb_logical_type t) {
+	duckdb_aggregate_function_set_return_type(f, t);
+}
+
+static void aggregate_set_special_handling(duckdb_aggregate_function f) {
+	duckdb_aggregate_function_set_special_handling(f);
+}
+
+static void aggregate_set_extra_info(duckdb_aggregate_function f, void *ptr) {
+	duckdb_aggregate_function_set_extra_info(f, ptr, extraInfoDestroy);
+}
+
+static duckdb_state aggregate_register(duckdb_connection conn, duckdb_aggregate_function f) {
+	return duckdb_register_aggregate_function(conn, f);
+}
+
+static void *aggregate_get_extra_info(duckdb_function_info info) {
+	return duckdb_aggregate_function_get_extra_info(info);
+}
+
+static void aggregate_set_error(duckdb_function_info info, const char *msg) {
+	duckdb_aggregate_function_set_error(info, msg);
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"runtime/cgo"
+	"unsafe"
+
+	duckdb "github.com/duckdb/duckdb-go-bindings"
+)
+
+// AggregateFunction describes an aggregate function for RegisterAggregateFunction.
+// States are arbitrary Go values, usually pointers, each kept behind a cgo.Handle in
+// DuckDB's state memory; a state with a Close method is closed when DuckDB destroys it.
+type AggregateFunction struct {
+	Name   string
+	Params []LogicalType
+	Return LogicalType
+
+	// SpecialNulls passes NULL inputs to Update instead of skipping those rows.
+	SpecialNulls bool
+
+	// Init returns a fresh state for one group.
+	Init func() any
+
+	// Update folds the rows of input into their states; states[i] belongs to row i.
+	Update func(info duckdb.FunctionInfo, input duckdb.DataChunk, states []any) error
+
+	// Combine merges source into target, e.g. states built by different threads.
+	Combine func(source, target any) error
+
+	// Finalize writes the result of states[i] to row offset+i of result.
+	Finalize func(info duckdb.FunctionInfo, states []any, result duckdb.Vector, offset int) error
+}
+
+// RegisterAggregateFunction registers an aggregate function with DuckDB using the C API.
+func RegisterAggregateFunction(conn duckdb.Connection, f AggregateFunction) error {
+	if f.Init == nil || f.Update == nil || f.Combine == nil || f.Finalize == nil {
+		return errors.New("aggregate function needs Init, Update, Combine and Finalize: " + f.Name)
+	}
+
+	name := C.CString(f.Name)
+	defer C.free(unsafe.Pointer(name))
+
+	funcHandle := C.aggregate_create(name)
+	defer C.aggregate_destroy(funcHandle)
+
+	for _, paramType := range f.Params {
+		logicalType := paramType.create()
+		C.aggregate_add_parameter(funcHandle, C.duckdb_logical_type(logicalType.Ptr))
+		duckdb.DestroyLogicalType(&logicalType)
+	}
+
+	returnLogicalType := f.Return.create()
+	C.aggregate_set_return_type(funcHandle, C.duckdb_logical_type(returnLogicalType.Ptr))
+	duckdb.DestroyLogicalType(&returnLogicalType)
+
+	if f.SpecialNulls {
+		C.aggregate_set_special_handling(funcHandle)
+	}
+
+	handlePtr, err := newHandlePtr(&f)
+	if err != nil {
+		return err
+	}
+	C.aggregate_set_extra_info(funcHandle, handlePtr)
+
+	state := C.aggregate_register(C.duckdb_connection(conn.Ptr), funcHandle)
+	if state == C.DuckDBError {
+		return errors.New("failed to register aggregate function: " + f.Name)
+	}
+
+	return nil
+}
+
+// AggregateInit creates the Go state for the state memory at state, which is
+// sizeof(uintptr_t) bytes as returned by aggregateStateSizeWrapper.
+func AggregateInit(info duckdb.FunctionInfo, state unsafe.Pointer) {
+	*(*C.uintptr_t)(state) = 0
+
+	f, ok := aggregateFunction(info)
+	if !ok {
+		return
+	}
+	*(*C.uintptr_t)(state) = C.uintptr_t(cgo.NewHandle(f.Init()))
+}
+
+// AggregateUpdate runs Update for one input chunk; states holds one state pointer per row.
+func AggregateUpdate(info duckdb.FunctionInfo, input duckdb.DataChunk, states unsafe.Pointer) {
+	f, ok := aggregateFunction(info)
+	if !ok {
+		return
+	}
+
+	n := int(duckdb.DataChunkGetSize(input))
+	if err := f.Update(info, input, aggregateStates(states, n)); err != nil {
+		setAggregateError(info, err)
+	}
+}
+
+// AggregateCombine runs Combine for count source/target state pairs.
+func AggregateCombine(info duckdb.FunctionInfo, source, target unsafe.Pointer, count uint64) {
+	f, ok := aggregateFunction(info)
+	if !ok {
+		return
+	}
+
+	src := aggregateStates(source, int(count))
+	dst := aggregateStates(target, int(count))
+	for i := range src {
+		if err := f.Combine(src[i], dst[i]); err != nil {
+			setAggregateError(info, err)
+			return
+		}
+	}
+}
+
+// AggregateFinalize runs Finalize for count states.
+func AggregateFinalize(info duckdb.FunctionInfo, source unsafe.Pointer, result duckdb.Vector, count, offset uint64) {
+	f, ok := aggregateFunction(info)
+	if !ok {
+		return
+	}
+
+	if err := f.Finalize(info, aggregateStates(source, int(count)), result, int(offset)); err != nil {
+		setAggregateError(info, err)
+	}
+}
+
+// AggregateDestroy releases the Go states of count state pointers.
+func AggregateDestroy(states unsafe.Pointer, count uint64) {
+	for _, p := range unsafe.Slice((*unsafe.Pointer)(states), int(count)) {
+		h := *(*C.uintptr_t)(p)
+		if h == 0 {
+			continue
+		}
+		v := cgo.Handle(h)
+		if c, ok := v.Value().(interface{ Close() }); ok {
+			c.Close()
+		}
+		v.Delete()
+		*(*C.uintptr_t)(p) = 0
+	}
+}
+
+func aggregateFunction(info duckdb.FunctionInfo) (*AggregateFunction, bool) {
+	f, ok := handleValue(C.aggregate_get_extra_info(C.duckdb_function_info(info.Ptr))).(*AggregateFunction)
+	if !ok {
+		setAggregateError(info, errors.New("aggregate function handle is missing"))
+	}
+	return f, ok
+}
+
+// aggregateStates resolves an array of n state pointers to their Go states.
+func aggregateStates(states unsafe.Pointer, n int) []any {
+	out := make([]any, n)
+	for i, p := range unsafe.Slice((*unsafe.Pointer)(states), n) {
+		if h := *(*C.uintptr_t)(p); h != 0 {
+			out[i] = cgo.Handle(h).Value()
+		}
+	}
+	return out
+}
+
+func setAggregateError(info duckdb.FunctionInfo, err error) {
+	msg := C.CString(err.Error())
+	defer C.free(unsafe.Pointer(msg))
+	C.aggregate_set_error(C.duckdb_function_info(info.Ptr), msg)
+}
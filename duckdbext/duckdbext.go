@@ -10,13 +10,17 @@ package duckdbext
 extern duckdb_ext_api_v1 duckdb_ext_api;
 
 void scalarFunctionWrapper(duckdb_function_info info, duckdb_data_chunk input, duckdb_vector output);
+void scalarBindWrapper(duckdb_bind_info info);
+void *bindDataCopyWrapper(void *ptr);
 void extraInfoDestroy(void *ptr);
 void extension_set_error(struct duckdb_extension_access *access, duckdb_extension_info info, const char *msg);
+duckdb_state extension_connect(struct duckdb_extension_access *access, duckdb_extension_info info, duckdb_connection *out);
 */
 import "C"
 
 import (
 	"errors"
+	"fmt"
 	"runtime/cgo"
 	"unsafe"
 
@@ -27,6 +31,36 @@ type (
 	// ScalarFuncImpl is the signature for Go scalar function implementations
 	ScalarFuncImpl func(info duckdb.FunctionInfo, input duckdb.DataChunk, output duckdb.Vector)
 
+	// ScalarBindFunc runs once per query before Func and returns its bind data.
+	ScalarBindFunc func(info ScalarBindInfo) (any, error)
+
+	// ScalarBindInfo wraps duckdb_bind_info for scalar function binds.
+	ScalarBindInfo struct {
+		info duckdb.BindInfo
+	}
+
+	// ScalarFunction describes a scalar function for RegisterScalarFunction.
+	ScalarFunction struct {
+		Name   string
+		Params []LogicalType
+		Return LogicalType
+
+		// VarArgs accepts any number of trailing arguments of this type, Any() for mixed types.
+		// The zero value means no varargs.
+		VarArgs LogicalType
+
+		// SpecialNulls passes rows with NULL arguments to Func instead of returning NULL.
+		SpecialNulls bool
+
+		// Volatile disables constant folding and caching across rows, e.g. for random output.
+		Volatile bool
+
+		// Bind is optional; Func reads its result with ScalarBindData.
+		Bind ScalarBindFunc
+
+		Func ScalarFuncImpl
+	}
+
 	// ExtensionInfo wraps duckdb_extension_info
 	ExtensionInfo struct {
 		Ptr unsafe.Pointer
@@ -40,70 +74,208 @@ type (
 
 // Dispatch looks up the Go function handle stored in DuckDB's extra_info and executes it.
 func Dispatch(info duckdb.FunctionInfo, input duckdb.DataChunk, output duckdb.Vector) {
-	extraInfo := duckdb.ScalarFunctionGetExtraInfo(info)
-	if extraInfo == nil {
+	f, ok := handleValue(duckdb.ScalarFunctionGetExtraInfo(info)).(*ScalarFunction)
+	if !ok {
 		return
 	}
 
-	h := cgo.Handle(*(*C.uintptr_t)(extraInfo))
-	impl, ok := h.Value().(ScalarFuncImpl)
+	f.Func(info, input, output)
+}
+
+// ScalarBind runs the bind callback of the scalar function stored in extra_info.
+func ScalarBind(info duckdb.BindInfo) {
+	f, ok := handleValue(duckdb.ScalarFunctionBindGetExtraInfo(info)).(*ScalarFunction)
 	if !ok {
+		duckdb.ScalarFunctionBindSetError(info, "scalar function handle is missing")
+		return
+	}
+
+	bindData, err := f.Bind(ScalarBindInfo{info: info})
+	if err != nil {
+		duckdb.ScalarFunctionBindSetError(info, err.Error())
 		return
 	}
 
-	impl(info, input, output)
+	ptr, err := newHandlePtr(bindData)
+	if err != nil {
+		duckdb.ScalarFunctionBindSetError(info, err.Error())
+		return
+	}
+	duckdb.ScalarFunctionSetBindData(info, ptr, unsafe.Pointer(C.extraInfoDestroy))
+	duckdb.ScalarFunctionSetBindDataCopy(info, unsafe.Pointer(C.bindDataCopyWrapper))
+}
+
+// ScalarBindData returns the value the Bind callback returned for this query, or nil.
+func ScalarBindData(info duckdb.FunctionInfo) any {
+	return handleValue(duckdb.ScalarFunctionGetBindData(info))
+}
+
+// SetFunctionError fails the query running the scalar function with err.
+func SetFunctionError(info duckdb.FunctionInfo, err error) {
+	duckdb.ScalarFunctionSetError(info, err.Error())
+}
+
+// ConnectionID identifies the connection (session) the query runs on.
+func (b ScalarBindInfo) ConnectionID() uint64 {
+	var ctx duckdb.ClientContext
+	duckdb.ScalarFunctionGetClientContext(b.info, &ctx)
+	defer duckdb.DestroyClientContext(&ctx)
+	return uint64(duckdb.ClientContextGetConnectionId(ctx))
+}
+
+// ArgumentCount returns the number of arguments of the call.
+func (b ScalarBindInfo) ArgumentCount() int {
+	return int(duckdb.ScalarFunctionBindGetArgumentCount(b.info))
+}
+
+// ConstantArgument folds argument i, which must be constant, e.g. an options STRUCT literal,
+// and returns it converted with ValueToGo.
+func (b ScalarBindInfo) ConstantArgument(i int) (any, error) {
+	expr := duckdb.ScalarFunctionBindGetArgument(b.info, duckdb.IdxT(i))
+	defer duckdb.DestroyExpression(&expr)
+
+	if !duckdb.ExpressionIsFoldable(expr) {
+		return nil, fmt.Errorf("argument %d must be a constant", i+1)
+	}
+
+	var ctx duckdb.ClientContext
+	duckdb.ScalarFunctionGetClientContext(b.info, &ctx)
+	defer duckdb.DestroyClientContext(&ctx)
+
+	var v duckdb.Value
+	errData := duckdb.ExpressionFold(ctx, expr, &v)
+	defer duckdb.DestroyErrorData(&errData)
+	defer duckdb.DestroyValue(&v)
+
+	if duckdb.ErrorDataHasError(errData) {
+		return nil, errors.New(duckdb.ErrorDataMessage(errData))
+	}
+	return ValueToGo(v)
+}
+
+// ArgumentType returns the type of argument i, e.g. the STRUCT passed for an ANY parameter.
+func (b ScalarBindInfo) ArgumentType(i int) LogicalType {
+	expr := duckdb.ScalarFunctionBindGetArgument(b.info, duckdb.IdxT(i))
+	defer duckdb.DestroyExpression(&expr)
+
+	lt := duckdb.ExpressionReturnType(expr)
+	defer duckdb.DestroyLogicalType(&lt)
+	return TypeOf(lt)
+}
+
+// CopyHandle returns a new handle pointer to the value behind ptr. The copy shares the
+// value, so values copied this way must not rely on Close.
+func CopyHandle(ptr unsafe.Pointer) unsafe.Pointer {
+	copied, err := newHandlePtr(handleValue(ptr))
+	if err != nil {
+		return nil
+	}
+	return copied
 }
 
-// DeleteHandle releases a Go handle allocated for DuckDB extra_info.
+// DeleteHandle releases a Go handle allocated for DuckDB extra_info, bind or init data,
+// closing the value first if it has a Close method.
 func DeleteHandle(ptr unsafe.Pointer) {
 	if ptr == nil {
 		return
 	}
 	h := cgo.Handle(*(*C.uintptr_t)(ptr))
+	if c, ok := h.Value().(interface{ Close() }); ok {
+		c.Close()
+	}
 	h.Delete()
 	C.free(ptr)
 }
 
 // RegisterScalarFunction registers a scalar function with DuckDB using the C API.
-func RegisterScalarFunction(
-	conn duckdb.Connection,
-	name string,
-	paramTypes []duckdb.Type,
-	returnType duckdb.Type,
-	impl ScalarFuncImpl,
-) error {
-	funcHandle := duckdb.CreateScalarFunction()
+func RegisterScalarFunction(conn duckdb.Connection, f ScalarFunction) error {
+	funcHandle, err := createScalarFunction(f)
+	if err != nil {
+		return err
+	}
 	defer duckdb.DestroyScalarFunction(&funcHandle)
 
-	duckdb.ScalarFunctionSetName(funcHandle, name)
+	state := duckdb.RegisterScalarFunction(conn, funcHandle)
+	if state == duckdb.StateError {
+		return errors.New("failed to register scalar function: " + f.Name)
+	}
+
+	return nil
+}
+
+// RegisterScalarFunctionSet registers overloads of one function, e.g. with and without an
+// options argument. The Name of the overloads is ignored.
+func RegisterScalarFunctionSet(conn duckdb.Connection, name string, overloads ...ScalarFunction) error {
+	set := duckdb.CreateScalarFunctionSet(name)
+	defer duckdb.DestroyScalarFunctionSet(&set)
+
+	for _, f := range overloads {
+		f.Name = name
+		funcHandle, err := createScalarFunction(f)
+		if err != nil {
+			return err
+		}
+		state := duckdb.AddScalarFunctionToSet(set, funcHandle)
+		duckdb.DestroyScalarFunction(&funcHandle)
+		if state == duckdb.StateError {
+			return errors.New("failed to add overload to scalar function set: " + name)
+		}
+	}
+
+	state := duckdb.RegisterScalarFunctionSet(conn, set)
+	if state == duckdb.StateError {
+		return errors.New("failed to register scalar function set: " + name)
+	}
+
+	return nil
+}
+
+// createScalarFunction builds the DuckDB function for f; the caller destroys it.
+func createScalarFunction(f ScalarFunction) (duckdb.ScalarFunction, error) {
+	if f.Func == nil {
+		return duckdb.ScalarFunction{}, errors.New("scalar function needs Func: " + f.Name)
+	}
 
-	for _, paramType := range paramTypes {
-		logicalType := duckdb.CreateLogicalType(paramType)
+	funcHandle := duckdb.CreateScalarFunction()
+
+	duckdb.ScalarFunctionSetName(funcHandle, f.Name)
+
+	for _, paramType := range f.Params {
+		logicalType := paramType.create()
 		duckdb.ScalarFunctionAddParameter(funcHandle, logicalType)
 		duckdb.DestroyLogicalType(&logicalType)
 	}
 
-	returnLogicalType := duckdb.CreateLogicalType(returnType)
+	if f.VarArgs.id != duckdb.TypeInvalid {
+		logicalType := f.VarArgs.create()
+		duckdb.ScalarFunctionSetVarargs(funcHandle, logicalType)
+		duckdb.DestroyLogicalType(&logicalType)
+	}
+
+	returnLogicalType := f.Return.create()
 	duckdb.ScalarFunctionSetReturnType(funcHandle, returnLogicalType)
 	duckdb.DestroyLogicalType(&returnLogicalType)
 
-	handle := cgo.NewHandle(impl)
-	handlePtr := C.malloc(C.sizeof_uintptr_t)
-	if handlePtr == nil {
-		handle.Delete()
-		return errors.New("failed to allocate extra_info")
+	if f.SpecialNulls {
+		duckdb.ScalarFunctionSetSpecialHandling(funcHandle)
+	}
+	if f.Volatile {
+		duckdb.ScalarFunctionSetVolatile(funcHandle)
+	}
+
+	handlePtr, err := newHandlePtr(&f)
+	if err != nil {
+		duckdb.DestroyScalarFunction(&funcHandle)
+		return duckdb.ScalarFunction{}, err
 	}
-	*(*C.uintptr_t)(handlePtr) = C.uintptr_t(handle)
 
 	duckdb.ScalarFunctionSetExtraInfo(funcHandle, handlePtr, unsafe.Pointer(C.extraInfoDestroy))
 	duckdb.ScalarFunctionSetFunction(funcHandle, unsafe.Pointer(C.scalarFunctionWrapper))
-
-	state := duckdb.RegisterScalarFunction(conn, funcHandle)
-	if state == duckdb.StateError {
-		return errors.New("failed to register scalar function: " + name)
+	if f.Bind != nil {
+		duckdb.ScalarFunctionSetBind(funcHandle, unsafe.Pointer(C.scalarBindWrapper))
 	}
 
-	return nil
+	return funcHandle, nil
 }
 
 // SetExtensionError sets an error message for the extension initialization.
@@ -116,3 +288,18 @@ func SetExtensionError(access ExtensionAccess, info ExtensionInfo, message strin
 		errMsg,
 	)
 }
+
+// Connect opens an additional connection to the database the extension is loaded into.
+// It stays valid after initialization, unlike the connection handed to the entrypoint.
+func Connect(access ExtensionAccess, info ExtensionInfo) (duckdb.Connection, error) {
+	var conn C.duckdb_connection
+	state := C.extension_connect(
+		(*C.struct_duckdb_extension_access)(access.Ptr),
+		C.duckdb_extension_info(info.Ptr),
+		&conn,
+	)
+	if state == C.DuckDBError {
+		return duckdb.Connection{}, errors.New("failed to open connection to database")
+	}
+	return duckdb.Connection{Ptr: unsafe.Pointer(conn)}, nil
+}
@@ -0,0 +1,43 @@
+package duckdbext
+
+/*
+// duckdb_pending_prepared_streaming is not part of the stable extension API struct, so it
+// is called directly, like the duckdb-go-bindings calls, instead of through duckdb_ext_api.
+#include <duckdb.h>
+*/
+import "C"
+
+import (
+	"errors"
+	"unsafe"
+
+	duckdb "github.com/duckdb/duckdb-go-bindings"
+)
+
+// QueryStreaming runs sql on conn without materializing its result: every duckdb.FetchChunk
+// on res computes the next chunk. conn must not run other queries until res is destroyed,
+// which the caller must do on success.
+func QueryStreaming(conn duckdb.Connection, sql string, res *duckdb.Result) error {
+	var stmt duckdb.PreparedStatement
+	if duckdb.Prepare(conn, sql, &stmt) == duckdb.StateError {
+		err := errors.New(duckdb.PrepareError(stmt))
+		duckdb.DestroyPrepare(&stmt)
+		return err
+	}
+	defer duckdb.DestroyPrepare(&stmt)
+
+	var cPending C.duckdb_pending_result
+	state := C.duckdb_pending_prepared_streaming(C.duckdb_prepared_statement(stmt.Ptr), &cPending)
+	pending := duckdb.PendingResult{Ptr: unsafe.Pointer(cPending)}
+	defer duckdb.DestroyPending(&pending)
+	if state == C.DuckDBError {
+		return errors.New(duckdb.PendingError(pending))
+	}
+
+	if duckdb.ExecutePending(pending, res) == duckdb.StateError {
+		err := errors.New(duckdb.ResultError(res))
+		duckdb.DestroyResult(res)
+		return err
+	}
+	return nil
+}
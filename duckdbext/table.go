@@ -0,0 +1,329 @@
+package duckdbext
+
+/*
+#include <duckdb_extension.h>
+#include <stdint.h>
+#include <stdlib.h>
+
+void tableBindWrapper(duckdb_bind_info info);
+void tableInitWrapper(duckdb_init_info info);
+void tableLocalInitWrapper(duckdb_init_info info);
+void tableFunctionWrapper(duckdb_function_info info, duckdb_data_chunk output);
+void extraInfoDestroy(void *ptr);
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"runtime/cgo"
+	"unsafe"
+
+	duckdb "github.com/duckdb/duckdb-go-bindings"
+)
+
+type (
+	// TableBindFunc reads the parameters, declares the result columns and returns the bind data.
+	TableBindFunc func(info TableBindInfo) (any, error)
+
+	// TableInitFunc creates the scan state, shared by all threads, from the bind data.
+	TableInitFunc func(info TableInitInfo, bindData any) (any, error)
+
+	// TableLocalInitFunc creates the state of one scanning thread from the bind data.
+	TableLocalInitFunc func(info TableInitInfo, bindData any) (any, error)
+
+	// TableFuncImpl fills output with the next rows; leaving output empty ends the scan.
+	TableFuncImpl func(scan TableScan, output duckdb.DataChunk) error
+
+	// TableFunction describes a table function for RegisterTableFunction.
+	TableFunction struct {
+		Name        string
+		Params      []LogicalType
+		NamedParams map[string]LogicalType
+		Bind        TableBindFunc
+		Init        TableInitFunc      // optional
+		LocalInit   TableLocalInitFunc // optional
+		Func        TableFuncImpl
+
+		// Projection enables projection pushdown: output then only holds the columns listed
+		// by TableInitInfo.Columns, in that order.
+		Projection bool
+	}
+
+	// TableBindInfo wraps duckdb_bind_info for table function binds.
+	TableBindInfo struct {
+		info duckdb.BindInfo
+	}
+
+	// TableInitInfo wraps duckdb_init_info.
+	TableInitInfo struct {
+		info duckdb.InitInfo
+	}
+
+	// TableScan is what TableFuncImpl gets on every call: the bind data, the global state from
+	// Init and the thread's state from LocalInit (nil when not set).
+	TableScan struct {
+		Info       duckdb.FunctionInfo
+		BindData   any
+		State      any
+		LocalState any
+	}
+)
+
+// RegisterTableFunction registers a table function with DuckDB using the C API.
+func RegisterTableFunction(conn duckdb.Connection, f TableFunction) error {
+	if f.Bind == nil || f.Func == nil {
+		return errors.New("table function needs Bind and Func: " + f.Name)
+	}
+
+	funcHandle := duckdb.CreateTableFunction()
+	defer duckdb.DestroyTableFunction(&funcHandle)
+
+	duckdb.TableFunctionSetName(funcHandle, f.Name)
+
+	for _, paramType := range f.Params {
+		logicalType := paramType.create()
+		duckdb.TableFunctionAddParameter(funcHandle, logicalType)
+		duckdb.DestroyLogicalType(&logicalType)
+	}
+	for name, paramType := range f.NamedParams {
+		logicalType := paramType.create()
+		duckdb.TableFunctionAddNamedParameter(funcHandle, name, logicalType)
+		duckdb.DestroyLogicalType(&logicalType)
+	}
+
+	handlePtr, err := newHandlePtr(&f)
+	if err != nil {
+		return err
+	}
+
+	duckdb.TableFunctionSetExtraInfo(funcHandle, handlePtr, unsafe.Pointer(C.extraInfoDestroy))
+	duckdb.TableFunctionSetBind(funcHandle, unsafe.Pointer(C.tableBindWrapper))
+	duckdb.TableFunctionSetInit(funcHandle, unsafe.Pointer(C.tableInitWrapper))
+	duckdb.TableFunctionSetFunction(funcHandle, unsafe.Pointer(C.tableFunctionWrapper))
+	if f.LocalInit != nil {
+		duckdb.TableFunctionSetLocalInit(funcHandle, unsafe.Pointer(C.tableLocalInitWrapper))
+	}
+	duckdb.TableFunctionSupportsProjectionPushdown(funcHandle, f.Projection)
+
+	state := duckdb.RegisterTableFunction(conn, funcHandle)
+	if state == duckdb.StateError {
+		return errors.New("failed to register table function: " + f.Name)
+	}
+
+	return nil
+}
+
+// TableBind runs the bind callback of the table function stored in extra_info.
+func TableBind(info duckdb.BindInfo) {
+	f, ok := handleValue(duckdb.BindGetExtraInfo(info)).(*TableFunction)
+	if !ok {
+		duckdb.BindSetError(info, "table function handle is missing")
+		return
+	}
+
+	bindData, err := f.Bind(TableBindInfo{info: info})
+	if err != nil {
+		duckdb.BindSetError(info, err.Error())
+		return
+	}
+
+	ptr, err := newHandlePtr(bindData)
+	if err != nil {
+		duckdb.BindSetError(info, err.Error())
+		return
+	}
+	duckdb.BindSetBindData(info, ptr, unsafe.Pointer(C.extraInfoDestroy))
+}
+
+// TableInit runs the init callback of the table function stored in extra_info.
+func TableInit(info duckdb.InitInfo) {
+	f, ok := handleValue(duckdb.InitGetExtraInfo(info)).(*TableFunction)
+	if !ok {
+		duckdb.InitSetError(info, "table function handle is missing")
+		return
+	}
+	if f.Init == nil {
+		return
+	}
+
+	state, err := f.Init(TableInitInfo{info: info}, handleValue(duckdb.InitGetBindData(info)))
+	if err != nil {
+		duckdb.InitSetError(info, err.Error())
+		return
+	}
+
+	ptr, err := newHandlePtr(state)
+	if err != nil {
+		duckdb.InitSetError(info, err.Error())
+		return
+	}
+	duckdb.InitSetInitData(info, ptr, unsafe.Pointer(C.extraInfoDestroy))
+}
+
+// TableLocalInit runs the local init callback of the table function stored in extra_info.
+func TableLocalInit(info duckdb.InitInfo) {
+	f, ok := handleValue(duckdb.InitGetExtraInfo(info)).(*TableFunction)
+	if !ok {
+		duckdb.InitSetError(info, "table function handle is missing")
+		return
+	}
+
+	state, err := f.LocalInit(TableInitInfo{info: info}, handleValue(duckdb.InitGetBindData(info)))
+	if err != nil {
+		duckdb.InitSetError(info, err.Error())
+		return
+	}
+
+	ptr, err := newHandlePtr(state)
+	if err != nil {
+		duckdb.InitSetError(info, err.Error())
+		return
+	}
+	duckdb.InitSetInitData(info, ptr, unsafe.Pointer(C.extraInfoDestroy))
+}
+
+// TableDispatch produces the next output chunk of the table function stored in extra_info.
+func TableDispatch(info duckdb.FunctionInfo, output duckdb.DataChunk) {
+	f, ok := handleValue(duckdb.FunctionGetExtraInfo(info)).(*TableFunction)
+	if !ok {
+		duckdb.FunctionSetError(info, "table function handle is missing")
+		return
+	}
+
+	scan := TableScan{
+		Info:       info,
+		BindData:   handleValue(duckdb.FunctionGetBindData(info)),
+		State:      handleValue(duckdb.FunctionGetInitData(info)),
+		LocalState: handleValue(duckdb.FunctionGetLocalInitData(info)),
+	}
+
+	if err := f.Func(scan, output); err != nil {
+		duckdb.FunctionSetError(info, err.Error())
+	}
+}
+
+// ParamCount returns the number of positional parameters.
+func (b TableBindInfo) ParamCount() int {
+	return int(duckdb.BindGetParameterCount(b.info))
+}
+
+// Param returns positional parameter i converted by ValueToGo.
+func (b TableBindInfo) Param(i int) (any, error) {
+	v := duckdb.BindGetParameter(b.info, duckdb.IdxT(i))
+	defer duckdb.DestroyValue(&v)
+	return ValueToGo(v)
+}
+
+// NamedParam returns a named parameter converted by ValueToGo and whether it was given.
+func (b TableBindInfo) NamedParam(name string) (any, bool, error) {
+	v := duckdb.BindGetNamedParameter(b.info, name)
+	if v.Ptr == nil {
+		return nil, false, nil
+	}
+	defer duckdb.DestroyValue(&v)
+
+	val, err := ValueToGo(v)
+	return val, true, err
+}
+
+// StringParam returns positional parameter i as a string, "" when NULL.
+func (b TableBindInfo) StringParam(i int) (string, error) {
+	v, err := b.Param(i)
+	if err != nil || v == nil {
+		return "", err
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("parameter %d: expected VARCHAR, got %T", i, v)
+	}
+	return s, nil
+}
+
+// NamedString returns a named VARCHAR parameter, or def when it was not given or is NULL.
+func (b TableBindInfo) NamedString(name, def string) (string, error) {
+	v, ok, err := b.NamedParam(name)
+	if err != nil || !ok || v == nil {
+		return def, err
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%s: expected VARCHAR, got %T", name, v)
+	}
+	return s, nil
+}
+
+// NamedStrings returns a named VARCHAR[] parameter without its NULL elements.
+func (b TableBindInfo) NamedStrings(name string) ([]string, bool, error) {
+	v, ok, err := b.NamedParam(name)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	list, err := Strings(v)
+	if err != nil {
+		return nil, true, fmt.Errorf("%s: %w", name, err)
+	}
+	return list, true, nil
+}
+
+// ConnectionID identifies the connection (session) the query runs on.
+func (b TableBindInfo) ConnectionID() uint64 {
+	var ctx duckdb.ClientContext
+	duckdb.TableFunctionGetClientContext(b.info, &ctx)
+	defer duckdb.DestroyClientContext(&ctx)
+	return uint64(duckdb.ClientContextGetConnectionId(ctx))
+}
+
+// AddResultColumn declares the next result column.
+func (b TableBindInfo) AddResultColumn(name string, t LogicalType) {
+	logicalType := t.create()
+	defer duckdb.DestroyLogicalType(&logicalType)
+	duckdb.BindAddResultColumn(b.info, name, logicalType)
+}
+
+// AddResultColumnType declares the next result column from an existing DuckDB logical type,
+// e.g. one taken from a query result. The caller keeps ownership of t.
+func (b TableBindInfo) AddResultColumnType(name string, t duckdb.LogicalType) {
+	duckdb.BindAddResultColumn(b.info, name, t)
+}
+
+// SetCardinality hints the number of rows the function will produce.
+func (b TableBindInfo) SetCardinality(rows uint64, exact bool) {
+	duckdb.BindSetCardinality(b.info, duckdb.IdxT(rows), exact)
+}
+
+// Columns returns the indexes of the result columns the query needs. With projection
+// pushdown enabled, output chunks hold exactly these columns in this order.
+func (i TableInitInfo) Columns() []int {
+	n := int(duckdb.InitGetColumnCount(i.info))
+	cols := make([]int, n)
+	for j := range cols {
+		cols[j] = int(duckdb.InitGetColumnIndex(i.info, duckdb.IdxT(j)))
+	}
+	return cols
+}
+
+// SetMaxThreads limits how many threads may call the function concurrently.
+func (i TableInitInfo) SetMaxThreads(n int) {
+	duckdb.InitSetMaxThreads(i.info, duckdb.IdxT(n))
+}
+
+// newHandlePtr stores v in a cgo.Handle behind a malloc'd pointer, released by DeleteHandle.
+func newHandlePtr(v any) (unsafe.Pointer, error) {
+	handle := cgo.NewHandle(v)
+	handlePtr := C.malloc(C.sizeof_uintptr_t)
+	if handlePtr == nil {
+		handle.Delete()
+		return nil, fmt.Errorf("failed to allocate handle")
+	}
+	*(*C.uintptr_t)(handlePtr) = C.uintptr_t(handle)
+	return handlePtr, nil
+}
+
+// handleValue returns the value behind a pointer created by newHandlePtr, or nil.
+func handleValue(ptr unsafe.Pointer) any {
+	if ptr == nil {
+		return nil
+	}
+	return cgo.Handle(*(*C.uintptr_t)(ptr)).Value()
+}
@@ -0,0 +1,192 @@
+package duckdbext
+
+import (
+	duckdb "github.com/duckdb/duckdb-go-bindings"
+)
+
+// LogicalType describes a DuckDB type for function registration, including nested types.
+type LogicalType struct {
+	id       duckdb.Type
+	children []LogicalType
+	names    []string // STRUCT field names or ENUM values
+	width    uint8    // DECIMAL
+	scale    uint8    // DECIMAL
+	size     int      // ARRAY
+}
+
+// StructField is one named field of a STRUCT type.
+type StructField struct {
+	Name string
+	Type LogicalType
+}
+
+// Primitive wraps a non-nested type such as duckdb.TypeVarchar.
+func Primitive(t duckdb.Type) LogicalType {
+	return LogicalType{id: t}
+}
+
+// Any matches arguments of every type; only valid for parameters.
+func Any() LogicalType {
+	return LogicalType{id: duckdb.TypeAny}
+}
+
+// Decimal is DECIMAL(width, scale).
+func Decimal(width, scale uint8) LogicalType {
+	return LogicalType{id: duckdb.TypeDecimal, width: width, scale: scale}
+}
+
+// Enum is an ENUM of the given values.
+func Enum(values ...string) LogicalType {
+	return LogicalType{id: duckdb.TypeEnum, names: values}
+}
+
+// List is child[].
+func List(child LogicalType) LogicalType {
+	return LogicalType{id: duckdb.TypeList, children: []LogicalType{child}}
+}
+
+// Array is child[size], e.g. FLOAT[384].
+func Array(child LogicalType, size int) LogicalType {
+	return LogicalType{id: duckdb.TypeArray, children: []LogicalType{child}, size: size}
+}
+
+// Map is MAP(key, value).
+func Map(key, value LogicalType) LogicalType {
+	return LogicalType{id: duckdb.TypeMap, children: []LogicalType{key, value}}
+}
+
+// Struct is STRUCT(name type, ...).
+func Struct(fields ...StructField) LogicalType {
+	t := LogicalType{id: duckdb.TypeStruct}
+	for _, f := range fields {
+		t.names = append(t.names, f.Name)
+		t.children = append(t.children, f.Type)
+	}
+	return t
+}
+
+// ID returns the type id, e.g. duckdb.TypeStruct.
+func (t LogicalType) ID() duckdb.Type {
+	return t.id
+}
+
+// Fields returns the fields of a STRUCT type.
+func (t LogicalType) Fields() []StructField {
+	if t.id != duckdb.TypeStruct {
+		return nil
+	}
+	fields := make([]StructField, len(t.names))
+	for i, name := range t.names {
+		fields[i] = StructField{Name: name, Type: t.children[i]}
+	}
+	return fields
+}
+
+// Child returns the element type of a LIST or ARRAY type.
+func (t LogicalType) Child() LogicalType {
+	if (t.id != duckdb.TypeList && t.id != duckdb.TypeArray) || len(t.children) == 0 {
+		return LogicalType{}
+	}
+	return t.children[0]
+}
+
+// Values returns the values of an ENUM type.
+func (t LogicalType) Values() []string {
+	if t.id != duckdb.TypeEnum {
+		return nil
+	}
+	return t.names
+}
+
+// Size returns the length of an ARRAY type.
+func (t LogicalType) Size() int {
+	return t.size
+}
+
+// create builds the DuckDB logical type; the caller must destroy it.
+func (t LogicalType) create() duckdb.LogicalType {
+	switch t.id {
+	case duckdb.TypeList:
+		child := t.children[0].create()
+		defer duckdb.DestroyLogicalType(&child)
+		return duckdb.CreateListType(child)
+
+	case duckdb.TypeArray:
+		child := t.children[0].create()
+		defer duckdb.DestroyLogicalType(&child)
+		return duckdb.CreateArrayType(child, duckdb.IdxT(t.size))
+
+	case duckdb.TypeMap:
+		key := t.children[0].create()
+		defer duckdb.DestroyLogicalType(&key)
+		value := t.children[1].create()
+		defer duckdb.DestroyLogicalType(&value)
+		return duckdb.CreateMapType(key, value)
+
+	case duckdb.TypeStruct:
+		types := make([]duckdb.LogicalType, len(t.children))
+		for i, c := range t.children {
+			types[i] = c.create()
+		}
+		defer func() {
+			for i := range types {
+				duckdb.DestroyLogicalType(&types[i])
+			}
+		}()
+		return duckdb.CreateStructType(types, t.names)
+
+	case duckdb.TypeDecimal:
+		return duckdb.CreateDecimalType(t.width, t.scale)
+
+	case duckdb.TypeEnum:
+		return duckdb.CreateEnumType(t.names)
+
+	default:
+		return duckdb.CreateLogicalType(t.id)
+	}
+}
+
+// TypeOf describes lt, e.g. the type of an ANY argument; lt stays owned by the caller.
+func TypeOf(lt duckdb.LogicalType) LogicalType {
+	switch id := duckdb.GetTypeId(lt); id {
+	case duckdb.TypeList:
+		child := duckdb.ListTypeChildType(lt)
+		defer duckdb.DestroyLogicalType(&child)
+		return List(TypeOf(child))
+
+	case duckdb.TypeArray:
+		child := duckdb.ArrayTypeChildType(lt)
+		defer duckdb.DestroyLogicalType(&child)
+		return Array(TypeOf(child), int(duckdb.ArrayTypeArraySize(lt)))
+
+	case duckdb.TypeMap:
+		key := duckdb.MapTypeKeyType(lt)
+		defer duckdb.DestroyLogicalType(&key)
+		value := duckdb.MapTypeValueType(lt)
+		defer duckdb.DestroyLogicalType(&value)
+		return Map(TypeOf(key), TypeOf(value))
+
+	case duckdb.TypeStruct:
+		n := duckdb.StructTypeChildCount(lt)
+		fields := make([]StructField, n)
+		for i := duckdb.IdxT(0); i < n; i++ {
+			child := duckdb.StructTypeChildType(lt, i)
+			fields[i] = StructField{Name: duckdb.StructTypeChildName(lt, i), Type: TypeOf(child)}
+			duckdb.DestroyLogicalType(&child)
+		}
+		return Struct(fields...)
+
+	case duckdb.TypeDecimal:
+		return Decimal(duckdb.DecimalWidth(lt), duckdb.DecimalScale(lt))
+
+	case duckdb.TypeEnum:
+		values := make([]string, duckdb.EnumDictionarySize(lt))
+		for i := range values {
+			values[i] = duckdb.EnumDictionaryValue(lt, duckdb.IdxT(i))
+		}
+		return Enum(values...)
+
+	default:
+		return Primitive(id)
+	}
+}
@@ -0,0 +1,87 @@
+package duckdbext
+
+import (
+	"fmt"
+
+	duckdb "github.com/duckdb/duckdb-go-bindings"
+)
+
+// ValueToGo converts a duckdb.Value to a Go value: nil for NULL, bool, int64, uint64, float64,
+// string, []any for lists and map[string]any for structs. The caller keeps ownership of v.
+func ValueToGo(v duckdb.Value) (any, error) {
+	if v.Ptr == nil || duckdb.IsNullValue(v) {
+		return nil, nil
+	}
+
+	// owned by v, must not be destroyed
+	t := duckdb.GetValueType(v)
+
+	switch id := duckdb.GetTypeId(t); id {
+	case duckdb.TypeBoolean:
+		return duckdb.GetBool(v), nil
+	case duckdb.TypeTinyInt, duckdb.TypeSmallInt, duckdb.TypeInteger, duckdb.TypeBigInt:
+		return duckdb.GetInt64(v), nil
+	case duckdb.TypeUTinyInt, duckdb.TypeUSmallInt, duckdb.TypeUInteger, duckdb.TypeUBigInt:
+		return duckdb.GetUInt64(v), nil
+	case duckdb.TypeFloat, duckdb.TypeDouble, duckdb.TypeDecimal:
+		return duckdb.GetDouble(v), nil
+	case duckdb.TypeVarchar:
+		return duckdb.GetVarchar(v), nil
+
+	case duckdb.TypeList:
+		n := duckdb.GetListSize(v)
+		out := make([]any, n)
+		for i := duckdb.IdxT(0); i < n; i++ {
+			child := duckdb.GetListChild(v, i)
+			val, err := ValueToGo(child)
+			duckdb.DestroyValue(&child)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = val
+		}
+		return out, nil
+
+	case duckdb.TypeStruct:
+		n := duckdb.StructTypeChildCount(t)
+		out := make(map[string]any, n)
+		for i := duckdb.IdxT(0); i < n; i++ {
+			child := duckdb.GetStructChild(v, i)
+			val, err := ValueToGo(child)
+			duckdb.DestroyValue(&child)
+			if err != nil {
+				return nil, err
+			}
+			out[duckdb.StructTypeChildName(t, i)] = val
+		}
+		return out, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported value type %d", id)
+	}
+}
+
+// Strings converts a Go value from ValueToGo into a []string, skipping NULL elements.
+func Strings(v any) ([]string, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	list, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("expected a list, got %T", v)
+	}
+
+	out := make([]string, 0, len(list))
+	for _, e := range list {
+		if e == nil {
+			continue
+		}
+		s, ok := e.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected a VARCHAR list element, got %T", e)
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
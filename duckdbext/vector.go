@@ -0,0 +1,111 @@
+package duckdbext
+
+import (
+	"unsafe"
+
+	duckdb "github.com/duckdb/duckdb-go-bindings"
+)
+
+// Fixed lists the Go types that match the in-memory layout of fixed-size DuckDB vectors:
+// BOOLEAN, TINYINT..BIGINT, UTINYINT..UBIGINT, FLOAT and DOUBLE.
+type Fixed interface {
+	~bool | ~int8 | ~int16 | ~int32 | ~int64 | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~float32 | ~float64
+}
+
+// Vector reads and writes the rows of a duckdb.Vector.
+type Vector struct {
+	vec      duckdb.Vector
+	data     unsafe.Pointer
+	validity unsafe.Pointer
+}
+
+// NewVector wraps vec.
+func NewVector(vec duckdb.Vector) Vector {
+	return Vector{
+		vec:      vec,
+		data:     duckdb.VectorGetData(vec),
+		validity: duckdb.VectorGetValidity(vec),
+	}
+}
+
+// ChunkVector wraps column col of chunk.
+func ChunkVector(chunk duckdb.DataChunk, col int) Vector {
+	return NewVector(duckdb.DataChunkGetVector(chunk, duckdb.IdxT(col)))
+}
+
+// Raw returns the wrapped duckdb.Vector.
+func (v Vector) Raw() duckdb.Vector {
+	return v.vec
+}
+
+// Valid reports whether row is not NULL.
+func (v Vector) Valid(row int) bool {
+	return duckdb.ValidityRowIsValid(v.validity, duckdb.IdxT(row))
+}
+
+// String reads row of a VARCHAR or BLOB vector.
+func (v Vector) String(row int) string {
+	return duckdb.StringTData(&unsafe.Slice((*duckdb.StringT)(v.data), row+1)[row])
+}
+
+// Get reads row of a fixed-size vector.
+func Get[T Fixed](v Vector, row int) T {
+	return unsafe.Slice((*T)(v.data), row+1)[row]
+}
+
+// List returns the child offset and length of row of a LIST or MAP vector.
+func (v Vector) List(row int) (offset, length int) {
+	o, l := duckdb.ListEntryMembers(&unsafe.Slice((*duckdb.ListEntry)(v.data), row+1)[row])
+	return int(o), int(l)
+}
+
+// ListChild returns the child vector of a LIST or MAP vector. Reserve list space with
+// ReserveList before taking the child for writing.
+func (v Vector) ListChild() Vector {
+	return NewVector(duckdb.ListVectorGetChild(v.vec))
+}
+
+// ArrayChild returns the child vector of an ARRAY vector; row r owns child rows
+// [r*size, (r+1)*size).
+func (v Vector) ArrayChild() Vector {
+	return NewVector(duckdb.ArrayVectorGetChild(v.vec))
+}
+
+// StructChild returns field i of a STRUCT vector.
+func (v Vector) StructChild(i int) Vector {
+	return NewVector(duckdb.StructVectorGetChild(v.vec, duckdb.IdxT(i)))
+}
+
+// SetNull marks row as NULL.
+func (v Vector) SetNull(row int) {
+	validity := v.validity
+	if validity == nil {
+		duckdb.VectorEnsureValidityWritable(v.vec)
+		validity = duckdb.VectorGetValidity(v.vec)
+	}
+	duckdb.ValiditySetRowInvalid(validity, duckdb.IdxT(row))
+}
+
+// SetString writes row of a VARCHAR vector.
+func (v Vector) SetString(row int, s string) {
+	duckdb.VectorAssignStringElement(v.vec, duckdb.IdxT(row), s)
+}
+
+// Set writes row of a fixed-size vector.
+func Set[T Fixed](v Vector, row int, val T) {
+	unsafe.Slice((*T)(v.data), row+1)[row] = val
+}
+
+// ReserveList grows the child of a LIST or MAP vector by n rows and returns the offset of
+// the first new child row.
+func (v Vector) ReserveList(n int) int {
+	size := int(duckdb.ListVectorGetSize(v.vec))
+	duckdb.ListVectorReserve(v.vec, duckdb.IdxT(size+n))
+	duckdb.ListVectorSetSize(v.vec, duckdb.IdxT(size+n))
+	return size
+}
+
+// SetList points row of a LIST or MAP vector at child rows [offset, offset+length).
+func (v Vector) SetList(row, offset, length int) {
+	unsafe.Slice((*duckdb.ListEntry)(v.data), row+1)[row] = duckdb.NewListEntry(uint64(offset), uint64(length))
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// Embedder turns texts into vectors. All vectors of one model have the same dimension.
+type Embedder interface {
+	Name() string
+	Embed(ctx context.Context, model string, texts []string) ([][]float32, error)
+}
+
+// defaultEmbedModels are the models of the embedding providers without QUACK_EMBED_MODEL.
+var defaultEmbedModels = map[string]string{
+	"openai": "text-embedding-3-small",
+	"ollama": "nomic-embed-text",
+	"hash":   "hash",
+}
+
+// embedProviderFromEnv returns QUACK_EMBED_PROVIDER, by default the LLM provider when it can
+// embed and hash for the mock. Anthropic has no embeddings API.
+func embedProviderFromEnv() (string, error) {
+	if name := os.Getenv("QUACK_EMBED_PROVIDER"); name != "" {
+		if _, ok := defaultEmbedModels[name]; !ok {
+			return "", fmt.Errorf("unknown QUACK_EMBED_PROVIDER %q, expected openai, ollama or hash", name)
+		}
+		return name, nil
+	}
+	switch name := os.Getenv("QUACK_LLM_PROVIDER"); name {
+	case "openai", "ollama":
+		return name, nil
+	case "mock":
+		return "hash", nil
+	}
+	return "", fmt.Errorf("provider %s has no embeddings, set QUACK_EMBED_PROVIDER to openai, ollama or hash", providerNameOr(os.Getenv("QUACK_LLM_PROVIDER")))
+}
+
+func providerNameOr(name string) string {
+	if name == "" {
+		return "anthropic"
+	}
+	return name
+}
+
+// embedModelFromEnv returns QUACK_EMBED_MODEL or the default model of provider.
+func embedModelFromEnv(provider string) string {
+	if m := os.Getenv("QUACK_EMBED_MODEL"); m != "" {
+		return m
+	}
+	return defaultEmbedModels[provider]
+}
+
+// newEmbedderFromEnv builds the embedding provider; apiKey overrides OPENAI_API_KEY.
+func newEmbedderFromEnv(provider, apiKey string) (Embedder, error) {
+	switch provider {
+	case "openai":
+		e := NewOpenAIEmbedderFromEnv()
+		if apiKey != "" {
+			e.apiKey = apiKey
+		}
+		return e, nil
+	case "ollama":
+		return NewOllamaEmbedderFromEnv(), nil
+	case "hash":
+		return NewHashEmbedderFromEnv()
+	}
+	return nil, fmt.Errorf("unknown embedding provider %q", provider)
+}
+
+// HashEmbedder is a deterministic, offline embedder for tests and demos. Words and their
+// character trigrams are hashed into a fixed number of dimensions with a random sign
+// (feature hashing), so texts sharing words or word parts have a high cosine similarity.
+// The model name seeds the hashes, so vectors of different models do not compare.
+type HashEmbedder struct {
+	dim int
+}
+
+// NewHashEmbedderFromEnv reads QUACK_EMBED_DIM (default 256).
+func NewHashEmbedderFromEnv() (*HashEmbedder, error) {
+	dim := 256
+	if n, ok := envInt("QUACK_EMBED_DIM"); ok {
+		if n < 1 {
+			return nil, fmt.Errorf("QUACK_EMBED_DIM must be positive, got %d", n)
+		}
+		dim = n
+	}
+	return &HashEmbedder{dim: dim}, nil
+}
+
+func (h *HashEmbedder) Name() string { return "hash" }
+
+func (h *HashEmbedder) Embed(ctx context.Context, model string, texts []string) ([][]float32, error) {
+	out := make([][]float32, len(texts))
+	for i, t := range texts {
+		out[i] = h.vector(model, t)
+	}
+	return out, nil
+}
+
+// vector is the L2-normalized feature vector of text, zero for a text without words.
+func (h *HashEmbedder) vector(model, text string) []float32 {
+	v := make([]float64, h.dim)
+	add := func(feature string, weight float64) {
+		sum := sha1.Sum([]byte(model + "\x00" + feature))
+		n := binary.BigEndian.Uint64(sum[:8])
+		if n&1 == 1 {
+			weight = -weight
+		}
+		v[(n>>1)%uint64(h.dim)] += weight
+	}
+
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		add("w:"+w, 1)
+		padded := []rune("^" + w + "$")
+		for j := 0; j+3 <= len(padded); j++ {
+			add("t:"+string(padded[j:j+3]), 0.5)
+		}
+	}
+
+	var norm float64
+	for _, x := range v {
+		norm += x * x
+	}
+	out := make([]float32, h.dim)
+	if norm == 0 {
+		return out
+	}
+	norm = math.Sqrt(norm)
+	for i, x := range v {
+		out[i] = float32(x / norm)
+	}
+	return out
+}
@@ -16,64 +16,329 @@ import (
 
 //export initExtension
 func initExtension(conn C.duckdb_connection, info C.duckdb_extension_info, access *C.struct_duckdb_extension_access) C.bool {
+	fail := func(msg string) C.bool {
+		duckdbext.SetExtensionError(
+			duckdbext.ExtensionAccess{Ptr: unsafe.Pointer(access)},
+			duckdbext.ExtensionInfo{Ptr: unsafe.Pointer(info)},
+			msg,
+		)
+		return C.bool(false)
+	}
+
 	mode := os.Getenv("QUACK_LLM_MODE")
 
-	dispatcher = nil
-	singleClient = nil
-	fusedDispatcher = nil
-
-	switch mode {
-	case "single":
-		c, err := NewAnthropicSingleClientFromEnv()
-		if err != nil {
-			duckdbext.SetExtensionError(
-				duckdbext.ExtensionAccess{Ptr: unsafe.Pointer(access)},
-				duckdbext.ExtensionInfo{Ptr: unsafe.Pointer(info)},
-				"Failed to init Anthropic single client: "+err.Error(),
-			)
-			return C.bool(false)
-		}
-		singleClient = c
-
-	case "fused":
-		c, err := NewAnthropicSingleClientFromEnv()
-		if err != nil {
-			duckdbext.SetExtensionError(
-				duckdbext.ExtensionAccess{Ptr: unsafe.Pointer(access)},
-				duckdbext.ExtensionInfo{Ptr: unsafe.Pointer(info)},
-				"Failed to init Anthropic single client (for fused mode): "+err.Error(),
-			)
-			return C.bool(false)
+	// Without an API key, e.g. when it comes from a quackai secret, the extension still loads
+	// and queries report the missing key at bind time. Any other provider error fails LOAD.
+	p, err := newProviderFromEnv(mode, "")
+	switch {
+	case err == nil:
+		if err := setupDispatch(mode, p); err != nil {
+			return fail("Failed to init " + p.Name() + " dispatch: " + err.Error())
 		}
+	case !isMissingKey(err):
+		return fail("Failed to init provider: " + err.Error())
+	}
 
-		fusedDispatcher = NewFusedDispatcher(c, ";")
-
-	default: // "batch"
-		c, err := NewAnthropicBatchClientFromEnv()
-		if err != nil {
-			duckdbext.SetExtensionError(
-				duckdbext.ExtensionAccess{Ptr: unsafe.Pointer(access)},
-				duckdbext.ExtensionInfo{Ptr: unsafe.Pointer(info)},
-				"Failed to init Anthropic batch client: "+err.Error(),
-			)
-			return C.bool(false)
-		}
-		dispatcher = NewLLMDispatcher(c)
+	varchar := duckdbext.Primitive(duckdb.TypeVarchar)
+
+	if err := duckdbext.RegisterScalarFunctionSet(
+		duckdb.Connection{Ptr: unsafe.Pointer(conn)},
+		"ai_llm",
+		duckdbext.ScalarFunction{
+			Params: []duckdbext.LogicalType{varchar, varchar},
+			Return: varchar,
+			Bind:   settingsBind,
+			Func:   aiLLM,
+		},
+		duckdbext.ScalarFunction{
+			Params: []duckdbext.LogicalType{varchar, varchar, duckdbext.Any()},
+			Return: varchar,
+			Bind:   optionsBind(2),
+			Func:   aiLLM,
+		},
+		duckdbext.ScalarFunction{
+			Params: []duckdbext.LogicalType{varchar, duckdbext.Any()},
+			Return: varchar,
+			Bind:   promptRefBind(1),
+			Func:   aiLLM,
+		},
+	); err != nil {
+		return fail("Failed to register ai_llm: " + err.Error())
+	}
+
+	if err := duckdbext.RegisterScalarFunctionSet(
+		duckdb.Connection{Ptr: unsafe.Pointer(conn)},
+		"ai_llm_multi",
+		duckdbext.ScalarFunction{
+			Params: []duckdbext.LogicalType{varchar, duckdbext.List(varchar)},
+			Return: duckdbext.Map(varchar, varchar),
+			Bind:   settingsBind,
+			Func:   aiLLMMulti,
+		},
+		duckdbext.ScalarFunction{
+			Params: []duckdbext.LogicalType{varchar, duckdbext.List(varchar), duckdbext.Any()},
+			Return: duckdbext.Map(varchar, varchar),
+			Bind:   multiOptionsBind,
+			Func:   aiLLMMulti,
+		},
+	); err != nil {
+		return fail("Failed to register ai_llm_multi: " + err.Error())
+	}
+
+	if err := duckdbext.RegisterScalarFunctionSet(
+		duckdb.Connection{Ptr: unsafe.Pointer(conn)},
+		"ai_llm_try",
+		duckdbext.ScalarFunction{
+			Params: []duckdbext.LogicalType{varchar, varchar},
+			Return: aiLLMTryType(),
+			Bind:   settingsBind,
+			Func:   aiLLMTry,
+		},
+		duckdbext.ScalarFunction{
+			Params: []duckdbext.LogicalType{varchar, varchar, duckdbext.Any()},
+			Return: aiLLMTryType(),
+			Bind:   optionsBind(2),
+			Func:   aiLLMTry,
+		},
+		duckdbext.ScalarFunction{
+			Params: []duckdbext.LogicalType{varchar, duckdbext.Any()},
+			Return: aiLLMTryType(),
+			Bind:   promptRefBind(1),
+			Func:   aiLLMTry,
+		},
+	); err != nil {
+		return fail("Failed to register ai_llm_try: " + err.Error())
+	}
+
+	boolean := duckdbext.Primitive(duckdb.TypeBoolean)
+
+	if err := duckdbext.RegisterScalarFunctionSet(
+		duckdb.Connection{Ptr: unsafe.Pointer(conn)},
+		"ai_classify",
+		duckdbext.ScalarFunction{
+			Params: []duckdbext.LogicalType{varchar, duckdbext.List(varchar)},
+			Return: varchar,
+			Bind:   classifyBind(-1, false),
+			Func:   aiClassify,
+		},
+		duckdbext.ScalarFunction{
+			Params: []duckdbext.LogicalType{varchar, duckdbext.List(varchar), duckdbext.Any()},
+			Return: varchar,
+			Bind:   classifyBind(2, false),
+			Func:   aiClassify,
+		},
+	); err != nil {
+		return fail("Failed to register ai_classify: " + err.Error())
+	}
+
+	if err := duckdbext.RegisterScalarFunctionSet(
+		duckdb.Connection{Ptr: unsafe.Pointer(conn)},
+		"ai_classify_confidence",
+		duckdbext.ScalarFunction{
+			Params: []duckdbext.LogicalType{varchar, duckdbext.List(varchar)},
+			Return: aiClassifyConfidenceType(),
+			Bind:   classifyBind(-1, true),
+			Func:   aiClassifyConfidence,
+		},
+		duckdbext.ScalarFunction{
+			Params: []duckdbext.LogicalType{varchar, duckdbext.List(varchar), duckdbext.Any()},
+			Return: aiClassifyConfidenceType(),
+			Bind:   classifyBind(2, true),
+			Func:   aiClassifyConfidence,
+		},
+	); err != nil {
+		return fail("Failed to register ai_classify_confidence: " + err.Error())
+	}
+
+	if err := duckdbext.RegisterScalarFunctionSet(
+		duckdb.Connection{Ptr: unsafe.Pointer(conn)},
+		"ai_filter",
+		duckdbext.ScalarFunction{
+			Params: []duckdbext.LogicalType{varchar, varchar},
+			Return: boolean,
+			Bind:   filterBind(-1, false),
+			Func:   aiFilter,
+		},
+		duckdbext.ScalarFunction{
+			Params: []duckdbext.LogicalType{varchar, varchar, duckdbext.Any()},
+			Return: boolean,
+			Bind:   filterBind(2, false),
+			Func:   aiFilter,
+		},
+	); err != nil {
+		return fail("Failed to register ai_filter: " + err.Error())
+	}
+
+	if err := duckdbext.RegisterScalarFunctionSet(
+		duckdb.Connection{Ptr: unsafe.Pointer(conn)},
+		"ai_filter_confidence",
+		duckdbext.ScalarFunction{
+			Params: []duckdbext.LogicalType{varchar, varchar},
+			Return: aiFilterConfidenceType(),
+			Bind:   filterBind(-1, true),
+			Func:   aiFilterConfidence,
+		},
+		duckdbext.ScalarFunction{
+			Params: []duckdbext.LogicalType{varchar, varchar, duckdbext.Any()},
+			Return: aiFilterConfidenceType(),
+			Bind:   filterBind(2, true),
+			Func:   aiFilterConfidence,
+		},
+	); err != nil {
+		return fail("Failed to register ai_filter_confidence: " + err.Error())
+	}
+
+	integer := duckdbext.Primitive(duckdb.TypeInteger)
+	double := duckdbext.Primitive(duckdb.TypeDouble)
+
+	// the types of the bounds select the result type: ai_score(text, 'rate urgency', 1, 5)
+	// returns INTEGER, ai_score(text, 'rate urgency', 0.0, 1.0) DOUBLE
+	if err := duckdbext.RegisterScalarFunctionSet(
+		duckdb.Connection{Ptr: unsafe.Pointer(conn)},
+		"ai_score",
+		duckdbext.ScalarFunction{
+			Params: []duckdbext.LogicalType{varchar, varchar, integer, integer},
+			Return: integer,
+			Bind:   scoreBind(-1, true),
+			Func:   aiScore,
+		},
+		duckdbext.ScalarFunction{
+			Params: []duckdbext.LogicalType{varchar, varchar, integer, integer, duckdbext.Any()},
+			Return: integer,
+			Bind:   scoreBind(4, true),
+			Func:   aiScore,
+		},
+		duckdbext.ScalarFunction{
+			Params: []duckdbext.LogicalType{varchar, varchar, double, double},
+			Return: double,
+			Bind:   scoreBind(-1, false),
+			Func:   aiScore,
+		},
+		duckdbext.ScalarFunction{
+			Params: []duckdbext.LogicalType{varchar, varchar, double, double, duckdbext.Any()},
+			Return: double,
+			Bind:   scoreBind(4, false),
+			Func:   aiScore,
+		},
+	); err != nil {
+		return fail("Failed to register ai_score: " + err.Error())
+	}
+
+	// FLOAT[] and not FLOAT[N]: the dimension depends on the model, which is only known when
+	// the call is bound, after the return type was fixed
+	embedding := duckdbext.List(duckdbext.Primitive(duckdb.TypeFloat))
+
+	if err := duckdbext.RegisterScalarFunctionSet(
+		duckdb.Connection{Ptr: unsafe.Pointer(conn)},
+		"ai_embed",
+		duckdbext.ScalarFunction{
+			Params: []duckdbext.LogicalType{varchar},
+			Return: embedding,
+			Bind:   embedBind(-1),
+			Func:   aiEmbed,
+		},
+		duckdbext.ScalarFunction{
+			Params: []duckdbext.LogicalType{varchar, duckdbext.Any()},
+			Return: embedding,
+			Bind:   embedBind(1),
+			Func:   aiEmbed,
+		},
+	); err != nil {
+		return fail("Failed to register ai_embed: " + err.Error())
 	}
 
 	if err := duckdbext.RegisterScalarFunction(
 		duckdb.Connection{Ptr: unsafe.Pointer(conn)},
-		"ai_llm",
-		[]duckdb.Type{duckdb.TypeVarchar, duckdb.TypeVarchar},
-		duckdb.TypeVarchar,
-		aiLLM,
+		aiPromptFunction(),
 	); err != nil {
-		duckdbext.SetExtensionError(
+		return fail("Failed to register ai_prompt: " + err.Error())
+	}
+
+	if err := duckdbext.RegisterTableFunction(
+		duckdb.Connection{Ptr: unsafe.Pointer(conn)},
+		aiPromptRegisterFunction(),
+	); err != nil {
+		return fail("Failed to register ai_prompt_register: " + err.Error())
+	}
+
+	if err := duckdbext.RegisterTableFunction(
+		duckdb.Connection{Ptr: unsafe.Pointer(conn)},
+		aiPromptsFunction(),
+	); err != nil {
+		return fail("Failed to register ai_prompts: " + err.Error())
+	}
+
+	enrichConn, err = duckdbext.Connect(
+		duckdbext.ExtensionAccess{Ptr: unsafe.Pointer(access)},
+		duckdbext.ExtensionInfo{Ptr: unsafe.Pointer(info)},
+	)
+	if err != nil {
+		return fail("Failed to open ai_enrich connection: " + err.Error())
+	}
+
+	if err := openEnrichScans(func() (duckdb.Connection, error) {
+		return duckdbext.Connect(
 			duckdbext.ExtensionAccess{Ptr: unsafe.Pointer(access)},
 			duckdbext.ExtensionInfo{Ptr: unsafe.Pointer(info)},
-			"Failed to register ai_llm: "+err.Error(),
 		)
-		return C.bool(false)
+	}); err != nil {
+		return fail("Failed to open ai_enrich scan connections: " + err.Error())
+	}
+
+	if err := duckdbext.RegisterTableFunction(
+		duckdb.Connection{Ptr: unsafe.Pointer(conn)},
+		aiEnrichFunction(),
+	); err != nil {
+		return fail("Failed to register ai_enrich: " + err.Error())
+	}
+
+	if err := duckdbext.RegisterTableFunction(
+		duckdb.Connection{Ptr: unsafe.Pointer(conn)},
+		aiExtractFunction(),
+	); err != nil {
+		return fail("Failed to register ai_extract: " + err.Error())
+	}
+
+	if err := duckdbext.RegisterTableFunction(
+		duckdb.Connection{Ptr: unsafe.Pointer(conn)},
+		quackaiSetFunction(),
+	); err != nil {
+		return fail("Failed to register quackai_set: " + err.Error())
+	}
+
+	if err := duckdbext.RegisterTableFunction(
+		duckdb.Connection{Ptr: unsafe.Pointer(conn)},
+		quackaiSettingsFunction(),
+	); err != nil {
+		return fail("Failed to register quackai_settings: " + err.Error())
+	}
+
+	if err := duckdbext.RegisterTableFunction(
+		duckdb.Connection{Ptr: unsafe.Pointer(conn)},
+		quackaiCreateSecretFunction(),
+	); err != nil {
+		return fail("Failed to register quackai_create_secret: " + err.Error())
+	}
+
+	if err := duckdbext.RegisterTableFunction(
+		duckdb.Connection{Ptr: unsafe.Pointer(conn)},
+		quackaiDropSecretFunction(),
+	); err != nil {
+		return fail("Failed to register quackai_drop_secret: " + err.Error())
+	}
+
+	if err := duckdbext.RegisterTableFunction(
+		duckdb.Connection{Ptr: unsafe.Pointer(conn)},
+		quackaiSecretsFunction(),
+	); err != nil {
+		return fail("Failed to register quackai_secrets: " + err.Error())
+	}
+
+	if err := duckdbext.RegisterAggregateFunction(
+		duckdb.Connection{Ptr: unsafe.Pointer(conn)},
+		aiSummarizeFunction(),
+	); err != nil {
+		return fail("Failed to register ai_summarize: " + err.Error())
 	}
 
 	return C.bool(true)
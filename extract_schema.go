@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// extractType is a type of an ai_extract spec such as 'name VARCHAR, tags VARCHAR[]': one of
+// the scalar types of extractScalars, a LIST of elem or a STRUCT of fields.
+type extractType struct {
+	name   string // VARCHAR, BOOLEAN, TINYINT, SMALLINT, INTEGER, BIGINT, FLOAT, DOUBLE, LIST or STRUCT
+	elem   *extractType
+	fields []extractField
+}
+
+type extractField struct {
+	name string
+	typ  extractType
+}
+
+// extractScalars maps the accepted spellings of scalar types to their DuckDB names.
+var extractScalars = map[string]string{
+	"VARCHAR":  "VARCHAR",
+	"TEXT":     "VARCHAR",
+	"STRING":   "VARCHAR",
+	"BOOLEAN":  "BOOLEAN",
+	"BOOL":     "BOOLEAN",
+	"TINYINT":  "TINYINT",
+	"SMALLINT": "SMALLINT",
+	"INTEGER":  "INTEGER",
+	"INT":      "INTEGER",
+	"BIGINT":   "BIGINT",
+	"FLOAT":    "FLOAT",
+	"REAL":     "FLOAT",
+	"DOUBLE":   "DOUBLE",
+}
+
+// parseExtractSpec parses a column list such as 'name VARCHAR, age INTEGER, tags VARCHAR[]'
+// into a STRUCT. Fields may nest as STRUCT(...) and end in [] for lists.
+func parseExtractSpec(spec string) (extractType, error) {
+	p := &specParser{s: spec}
+	fields, err := p.fields()
+	if err != nil {
+		return extractType{}, err
+	}
+	if p.skipSpace(); p.pos < len(p.s) {
+		return extractType{}, fmt.Errorf("unexpected %q at %d", p.s[p.pos:], p.pos)
+	}
+	return extractType{name: "STRUCT", fields: fields}, nil
+}
+
+type specParser struct {
+	s   string
+	pos int
+}
+
+func (p *specParser) skipSpace() {
+	for p.pos < len(p.s) && strings.ContainsRune(" \t\r\n", rune(p.s[p.pos])) {
+		p.pos++
+	}
+}
+
+// consume skips c and reports whether it was next.
+func (p *specParser) consume(c byte) bool {
+	p.skipSpace()
+	if p.pos < len(p.s) && p.s[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *specParser) fields() ([]extractField, error) {
+	var fields []extractField
+	for {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range fields {
+			if strings.EqualFold(f.name, name) {
+				return nil, fmt.Errorf("duplicate field %q", name)
+			}
+		}
+		t, err := p.typ()
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %w", name, err)
+		}
+		fields = append(fields, extractField{name: name, typ: t})
+
+		if !p.consume(',') {
+			return fields, nil
+		}
+	}
+}
+
+// name reads an identifier or a "quoted name".
+func (p *specParser) name() (string, error) {
+	p.skipSpace()
+	if p.consume('"') {
+		end := strings.IndexByte(p.s[p.pos:], '"')
+		if end <= 0 {
+			return "", fmt.Errorf("unterminated or empty quoted name at %d", p.pos-1)
+		}
+		name := p.s[p.pos : p.pos+end]
+		p.pos += end + 1
+		return name, nil
+	}
+
+	start := p.pos
+	for p.pos < len(p.s) && isIdentifier(p.s[start:p.pos+1]) {
+		p.pos++
+	}
+	if p.pos == start {
+		if p.pos == len(p.s) {
+			return "", errors.New("expected a field name at the end")
+		}
+		return "", fmt.Errorf("expected a field name at %d", p.pos)
+	}
+	return p.s[start:p.pos], nil
+}
+
+func (p *specParser) typ() (extractType, error) {
+	word, err := p.name()
+	if err != nil {
+		return extractType{}, errors.New("expected a type")
+	}
+
+	var t extractType
+	if upper := strings.ToUpper(word); upper == "STRUCT" {
+		if !p.consume('(') {
+			return extractType{}, errors.New("expected ( after STRUCT")
+		}
+		fields, err := p.fields()
+		if err != nil {
+			return extractType{}, err
+		}
+		if !p.consume(')') {
+			return extractType{}, errors.New("expected ) to close STRUCT")
+		}
+		t = extractType{name: "STRUCT", fields: fields}
+	} else if name, ok := extractScalars[upper]; ok {
+		t = extractType{name: name}
+	} else {
+		return extractType{}, fmt.Errorf("type %s is not supported, use VARCHAR, BOOLEAN, an integer type, FLOAT, DOUBLE, STRUCT(...) or a list of these", word)
+	}
+
+	for p.consume('[') {
+		if !p.consume(']') {
+			return extractType{}, errors.New("expected ] after [")
+		}
+		elem := t
+		t = extractType{name: "LIST", elem: &elem}
+	}
+	return t, nil
+}
+
+// String is the DuckDB spelling of t.
+func (t extractType) String() string {
+	switch t.name {
+	case "LIST":
+		return t.elem.String() + "[]"
+	case "STRUCT":
+		parts := make([]string, len(t.fields))
+		for i, f := range t.fields {
+			parts[i] = strconv.Quote(f.name) + " " + f.typ.String()
+		}
+		return "STRUCT(" + strings.Join(parts, ", ") + ")"
+	}
+	return t.name
+}
+
+// jsonSchema is the JSON schema of t. Values may be null, except for the top-level object,
+// so the model can leave out what the text does not say.
+func (t extractType) jsonSchema(top bool) map[string]any {
+	var s map[string]any
+	switch t.name {
+	case "VARCHAR":
+		s = map[string]any{"type": "string"}
+	case "BOOLEAN":
+		s = map[string]any{"type": "boolean"}
+	case "TINYINT", "SMALLINT", "INTEGER", "BIGINT":
+		s = map[string]any{"type": "integer"}
+	case "FLOAT", "DOUBLE":
+		s = map[string]any{"type": "number"}
+	case "LIST":
+		s = map[string]any{"type": "array", "items": t.elem.jsonSchema(false)}
+	case "STRUCT":
+		props := make(map[string]any, len(t.fields))
+		required := make([]string, len(t.fields))
+		for i, f := range t.fields {
+			props[f.name] = f.typ.jsonSchema(false)
+			required[i] = f.name
+		}
+		s = map[string]any{
+			"type":                 "object",
+			"properties":           props,
+			"required":             required,
+			"additionalProperties": false,
+		}
+	}
+	if !top {
+		s["type"] = []string{s["type"].(string), "null"}
+	}
+	return s
+}
+
+// schemaJSON is the JSON schema of the STRUCT t as sent to providers.
+func (t extractType) schemaJSON() string {
+	b, _ := json.Marshal(t.jsonSchema(true))
+	return string(b)
+}
+
+// parseExtractReply reads the JSON object of a reply to t, also when the model wrapped it in
+// prose or a code fence, and converts it to the Go values of t: nil for NULL, string, bool,
+// int64, float64, []any for lists and []any in field order for structs.
+func parseExtractReply(t extractType, reply string) ([]any, error) {
+	start, end := strings.IndexByte(reply, '{'), strings.LastIndexByte(reply, '}')
+	if start < 0 || end < start {
+		return nil, errors.New("the answer has no JSON object")
+	}
+
+	dec := json.NewDecoder(bytes.NewReader([]byte(reply[start : end+1])))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("the answer is not valid JSON: %w", err)
+	}
+
+	out, err := t.convert(v)
+	if err != nil {
+		return nil, err
+	}
+	if out == nil {
+		return nil, errors.New("the answer is null")
+	}
+	return out.([]any), nil
+}
+
+// convert checks the decoded JSON value v against t. It accepts what is unambiguous, such as
+// "42" for an INTEGER or 3.0 for a BIGINT, and rejects the rest.
+func (t extractType) convert(v any) (any, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	switch t.name {
+	case "VARCHAR":
+		switch x := v.(type) {
+		case string:
+			return x, nil
+		case json.Number:
+			return x.String(), nil
+		case bool:
+			return strconv.FormatBool(x), nil
+		}
+
+	case "BOOLEAN":
+		switch x := v.(type) {
+		case bool:
+			return x, nil
+		case string:
+			switch strings.ToLower(strings.TrimSpace(x)) {
+			case "true", "yes":
+				return true, nil
+			case "false", "no":
+				return false, nil
+			}
+		}
+
+	case "TINYINT", "SMALLINT", "INTEGER", "BIGINT":
+		f, ok := jsonFloat(v)
+		if !ok || f != math.Trunc(f) {
+			break
+		}
+		bits := map[string]int{"TINYINT": 8, "SMALLINT": 16, "INTEGER": 32, "BIGINT": 64}[t.name]
+		if n, isNum := v.(json.Number); isNum {
+			if i, err := strconv.ParseInt(n.String(), 10, bits); err == nil {
+				return i, nil
+			}
+		}
+		if f < -math.Ldexp(1, bits-1) || f >= math.Ldexp(1, bits-1) {
+			return nil, fmt.Errorf("%v is out of range for %s", v, t.name)
+		}
+		return int64(f), nil
+
+	case "FLOAT", "DOUBLE":
+		if f, ok := jsonFloat(v); ok {
+			return f, nil
+		}
+
+	case "LIST":
+		list, ok := v.([]any)
+		if !ok {
+			break
+		}
+		out := make([]any, len(list))
+		for i, e := range list {
+			c, err := t.elem.convert(e)
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+			out[i] = c
+		}
+		return out, nil
+
+	case "STRUCT":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			break
+		}
+		out := make([]any, len(t.fields))
+		for i, f := range t.fields {
+			for k, e := range obj {
+				if !strings.EqualFold(k, f.name) {
+					continue
+				}
+				c, err := f.typ.convert(e)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", f.name, err)
+				}
+				out[i] = c
+				break
+			}
+		}
+		return out, nil
+	}
+
+	b, _ := json.Marshal(v)
+	return nil, fmt.Errorf("%s is not a %s", b, t)
+}
+
+// jsonFloat reads a JSON number, or a string holding one.
+func jsonFloat(v any) (float64, bool) {
+	var s string
+	switch x := v.(type) {
+	case json.Number:
+		s = x.String()
+	case string:
+		s = strings.TrimSpace(x)
+	default:
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	return f, err == nil && !math.IsInf(f, 0) && !math.IsNaN(f)
+}
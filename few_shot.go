@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+
+	duckdb "github.com/duckdb/duckdb-go-bindings"
+	"github.com/mlafeldt/quack-go/duckdbext"
+)
+
+// maxExamples caps the examples of one query; each is sent with every request.
+const maxExamples = 100
+
+// loadExamples reads the few-shot examples of the examples option: the input and output
+// columns of a table or query. Rows with a NULL input or output are skipped. Examples are
+// sorted by input and output, since scans of a table or view need not keep its order, and
+// the order is part of every request, id and cache key.
+func loadExamples(source string) ([]Example, error) {
+	from, err := enrichSourceSQL(source)
+	if err != nil {
+		return nil, fmt.Errorf("examples: %w", err)
+	}
+	sql := fmt.Sprintf(
+		"SELECT CAST(input AS VARCHAR), CAST(output AS VARCHAR) FROM %s WHERE input IS NOT NULL AND output IS NOT NULL ORDER BY 1, 2 LIMIT %d",
+		from, maxExamples+1,
+	)
+
+	var res duckdb.Result
+	if err := enrichQuery(sql, &res); err != nil {
+		return nil, fmt.Errorf("examples: %w (the table needs columns input and output)", err)
+	}
+	defer duckdb.DestroyResult(&res)
+
+	var examples []Example
+	for {
+		chunk := duckdb.FetchChunk(res)
+		if chunk.Ptr == nil {
+			break
+		}
+		input := duckdbext.ChunkVector(chunk, 0)
+		output := duckdbext.ChunkVector(chunk, 1)
+		for row := 0; row < int(duckdb.DataChunkGetSize(chunk)); row++ {
+			examples = append(examples, Example{Input: input.String(row), Output: output.String(row)})
+		}
+		duckdb.DestroyDataChunk(&chunk)
+	}
+
+	switch {
+	case len(examples) == 0:
+		return nil, fmt.Errorf("examples: %s has no rows with both input and output", source)
+	case len(examples) > maxExamples:
+		return nil, fmt.Errorf("examples: %s has more than %d examples", source, maxExamples)
+	}
+	return examples, nil
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MockProvider is a deterministic, offline provider for tests and demos. Answers are rendered
+// from a template, failures are injected from a hash of the request and its attempt number, so
+// retries of a failed request may succeed while a rerun reproduces the same sequence.
+type MockProvider struct {
+	latency  time.Duration
+	failRate float64
+	template string
+
+	mu       sync.Mutex
+	attempts map[string]int
+}
+
+// NewMockProviderFromEnv reads QUACK_MOCK_LATENCY_MS (default 40), QUACK_MOCK_FAIL_RATE
+// (0..1, default 0.0625) and QUACK_MOCK_TEMPLATE with {id}, {text} and {prompt} placeholders.
+func NewMockProviderFromEnv() (*MockProvider, error) {
+	m := &MockProvider{
+		latency:  40 * time.Millisecond,
+		failRate: 1.0 / 16,
+		template: "ai_llm[{id}]: {text} | prompt={prompt}",
+		attempts: make(map[string]int),
+	}
+
+	if ms, ok := envInt("QUACK_MOCK_LATENCY_MS"); ok && ms >= 0 {
+		m.latency = time.Duration(ms) * time.Millisecond
+	}
+	if v := strings.TrimSpace(os.Getenv("QUACK_MOCK_FAIL_RATE")); v != "" {
+		r, err := strconv.ParseFloat(v, 64)
+		if err != nil || r < 0 || r > 1 {
+			return nil, fmt.Errorf("QUACK_MOCK_FAIL_RATE must be between 0 and 1, got %q", v)
+		}
+		m.failRate = r
+	}
+	if t := os.Getenv("QUACK_MOCK_TEMPLATE"); t != "" {
+		m.template = t
+	}
+
+	return m, nil
+}
+
+func (m *MockProvider) Name() string { return "mock" }
+
+func (m *MockProvider) DefaultModel() string { return "mock" }
+
+func (m *MockProvider) Capabilities() Capability { return CapBatch }
+
+func (m *MockProvider) Complete(ctx context.Context, req CompletionRequest) (Completion, error) {
+	if err := m.sleep(ctx); err != nil {
+		return Completion{}, err
+	}
+
+	if m.shouldFail(req.Text + "\x00" + req.Prompt) {
+		return Completion{}, fmt.Errorf("mock: injected error")
+	}
+
+	// fused requests carry everything in the prompt
+	if req.Text == "" {
+		if ans, ok := m.answerNumbered(req.Prompt); ok {
+			return m.completion(req, ans), nil
+		}
+		if ans, ok := m.answerFused(req.Prompt); ok {
+			return m.completion(req, ans), nil
+		}
+	}
+
+	return m.completion(req, m.reply(req)), nil
+}
+
+func (m *MockProvider) RunBatch(
+	ctx context.Context,
+	reqs []CompletionRequest,
+	pollEvery time.Duration,
+	pollTimeout time.Duration,
+) (map[string]Completion, error) {
+	if err := m.sleep(ctx); err != nil {
+		return nil, err
+	}
+
+	// failed items come back empty, like errored results of a message batch
+	out := make(map[string]Completion, len(reqs))
+	for _, r := range reqs {
+		if m.shouldFail(r.Text + "\x00" + r.Prompt) {
+			out[r.CustomID] = Completion{Failure: "errored"}
+			continue
+		}
+		out[r.CustomID] = m.completion(r, m.reply(r))
+	}
+	return out, nil
+}
+
+// completion wraps an answer with a rough 4-bytes-per-token usage estimate and, when asked
+// for, a log probability between log(0.5) and 0 derived from the answer.
+func (m *MockProvider) completion(req CompletionRequest, answer string) Completion {
+	c := Completion{
+		Text:  answer,
+		Model: "mock",
+		Usage: Usage{
+			InputTokens:  (len(req.Text) + len(req.Prompt) + 3) / 4,
+			OutputTokens: (len(answer) + 3) / 4,
+		},
+	}
+	if req.Logprobs {
+		h := sha1.Sum([]byte(req.Text + "\x00" + answer))
+		lp := math.Log(0.5 + float64(binary.BigEndian.Uint32(h[:4]))/(1<<33))
+		c.Logprob = &lp
+	}
+	return c
+}
+
+func (m *MockProvider) sleep(ctx context.Context) error {
+	if m.latency <= 0 {
+		return nil
+	}
+	select {
+	case <-time.After(m.latency):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m *MockProvider) shouldFail(key string) bool {
+	if m.failRate <= 0 {
+		return false
+	}
+
+	m.mu.Lock()
+	attempt := m.attempts[key]
+	m.attempts[key] = attempt + 1
+	m.mu.Unlock()
+
+	h := sha1.Sum([]byte(key + "\x00" + strconv.Itoa(attempt)))
+	return float64(binary.BigEndian.Uint32(h[:4]))/(1<<32) < m.failRate
+}
+
+// reply answers a text+prompt request, with JSON when it has a schema.
+func (m *MockProvider) reply(req CompletionRequest) string {
+	if req.Schema == "" {
+		if ans, ok := mockChoice(req.Text, req.Prompt); ok {
+			return ans
+		}
+		return m.answer(req.Text, req.Prompt)
+	}
+	var schema map[string]any
+	if err := json.Unmarshal([]byte(req.Schema), &schema); err != nil {
+		return "{}"
+	}
+	b, _ := json.Marshal(mockValue(schema, req.Text, req.Prompt))
+	return string(b)
+}
+
+// mockValue is a value of schema derived from a hash of text and path, the location in the
+// answer, so structured calls work offline too. Strings are the text itself.
+func mockValue(schema map[string]any, text, path string) any {
+	typ, _ := schema["type"].(string)
+	if types, ok := schema["type"].([]any); ok && len(types) > 0 {
+		typ, _ = types[0].(string)
+	}
+
+	h := sha1.Sum([]byte(text + "\x00" + path))
+	n := binary.BigEndian.Uint32(h[:4])
+
+	switch typ {
+	case "string":
+		return text
+	case "integer":
+		return n % 100
+	case "number":
+		return float64(n%1000) / 10
+	case "boolean":
+		return n%2 == 0
+	case "array":
+		items, _ := schema["items"].(map[string]any)
+		return []any{mockValue(items, text, path+"[]")}
+	case "object":
+		props, _ := schema["properties"].(map[string]any)
+		out := make(map[string]any, len(props))
+		for name, p := range props {
+			ps, _ := p.(map[string]any)
+			out[name] = mockValue(ps, text, path+"."+name)
+		}
+		return out
+	}
+	return nil
+}
+
+var (
+	mockLabels = regexp.MustCompile(`one of these labels[^\n]*((?:\n- [^\n]+)+)`)
+	mockYesNo  = regexp.MustCompile(`(?i)answer with yes or no`)
+	mockScale  = regexp.MustCompile(`only a (whole )?number from (\S+) to (\S+?)\.(?:\s|$)`)
+)
+
+// mockChoice answers the instructions of ai_classify, ai_filter and ai_score with one of their
+// labels, yes or no, or a number of the scale, picked by a hash of text, so they work offline
+// too.
+func mockChoice(text, prompt string) (string, bool) {
+	h := sha1.Sum([]byte(text))
+	n := binary.BigEndian.Uint32(h[:4])
+
+	if m := mockLabels.FindStringSubmatch(prompt); m != nil {
+		labels := strings.Split(strings.TrimPrefix(m[1], "\n- "), "\n- ")
+		return labels[n%uint32(len(labels))], true
+	}
+	if m := mockScale.FindStringSubmatch(prompt); m != nil {
+		lo, _ := strconv.ParseFloat(m[2], 64)
+		hi, _ := strconv.ParseFloat(m[3], 64)
+		if m[1] != "" {
+			return strconv.Itoa(int(lo) + int(n%uint32(hi-lo+1))), true
+		}
+		return strconv.FormatFloat(lo+(hi-lo)*float64(n%1001)/1000, 'f', 2, 64), true
+	}
+	if mockYesNo.MatchString(prompt) {
+		if n%2 == 0 {
+			return "yes", true
+		}
+		return "no", true
+	}
+	return "", false
+}
+
+func (m *MockProvider) answer(text, prompt string) string {
+	h := sha1.Sum([]byte(text + "\x00" + prompt))
+	return strings.NewReplacer(
+		"{id}", hex.EncodeToString(h[:4]),
+		"{text}", text,
+		"{prompt}", prompt,
+	).Replace(m.template)
+}
+
+var mockFusedSep = regexp.MustCompile(`separated by ["'](.+?)["']`)
+
+// answerFused answers the prompts built by FusedDispatcher: one line per TEXT/INSTRUCTIONS
+// pair, each holding the answers joined by the requested separator.
+func (m *MockProvider) answerFused(prompt string) (string, bool) {
+	match := mockFusedSep.FindStringSubmatch(prompt)
+	if match == nil {
+		return "", false
+	}
+	sep := match[1]
+
+	var (
+		lines []string
+		text  string
+	)
+	for _, ln := range strings.Split(prompt, "\n") {
+		switch {
+		case strings.HasPrefix(ln, "TEXT:"):
+			text = strings.TrimPrefix(ln, "TEXT:")
+		case strings.HasPrefix(ln, "INSTRUCTIONS:"):
+			instr := strings.Split(strings.TrimPrefix(ln, "INSTRUCTIONS:"), sep)
+			answers := make([]string, 0, len(instr))
+			for _, p := range instr {
+				a := m.answer(text, p)
+				a = strings.NewReplacer(sep, " ", "\n", " ", "\r", " ").Replace(a)
+				answers = append(answers, a)
+			}
+			lines = append(lines, strings.Join(answers, sep))
+		}
+	}
+	if len(lines) == 0 {
+		return "", false
+	}
+
+	return strings.Join(lines, "\n"), true
+}
+
+// answerNumbered answers the prompts of FusedDispatcher.runNumberedRequest with a JSON array.
+func (m *MockProvider) answerNumbered(prompt string) (string, bool) {
+	i := strings.Index(prompt, "TEXT:")
+	j := strings.Index(prompt, "\nINSTRUCTIONS_JSON:")
+	if i < 0 || j < i {
+		return "", false
+	}
+	text := prompt[i+len("TEXT:") : j]
+
+	rest := prompt[j+len("\nINSTRUCTIONS_JSON:"):]
+	if k := strings.Index(rest, "\n"); k >= 0 {
+		rest = rest[:k]
+	}
+	var instructions []string
+	if err := json.Unmarshal([]byte(rest), &instructions); err != nil {
+		return "", false
+	}
+
+	answers := make([]string, len(instructions))
+	for k, p := range instructions {
+		answers[k] = m.answer(text, p)
+	}
+	b, _ := json.Marshal(answers)
+	return string(b), true
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// tryLLM answers texts[i]+prompts[i] through the mode of ds and keeps everything known about
+// each answer instead of collapsing failures to NULL.
+func tryLLM(ds *dispatchSet, texts, prompts []string) []llmOutcome {
+	out := make([]llmOutcome, len(texts))
+
+	switch {
+	case ds.fusedDispatcher != nil:
+		parallelRows(len(texts), func(i int) {
+			out[i] = ds.fusedDispatcher.GetOutcome(texts[i], prompts[i])
+		})
+
+	case ds.singleProvider != nil:
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		defer cancel()
+
+		parallelRows(len(texts), func(i int) {
+			rowCtx, attempts := withAttemptCounter(ctx)
+			c, err := ds.singleProvider.Complete(rowCtx, CompletionRequest{Text: texts[i], Prompt: prompts[i]})
+			out[i] = llmOutcome{Value: c.Text, Err: err, Attempts: attemptsMade(attempts), Logprob: c.Logprob}
+			if c.Cached {
+				out[i].Attempts, out[i].Cached = 0, true
+			}
+		})
+
+	case ds.dispatcher != nil:
+		jobs := make([]llmJob, 0, len(texts))
+		seen := make(map[string]bool, len(texts))
+		for i := range texts {
+			cid := customID(texts[i], prompts[i])
+			if !seen[cid] {
+				seen[cid] = true
+				jobs = append(jobs, llmJob{text: texts[i], prompt: prompts[i]})
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+		defer cancel()
+
+		resMap, err := ds.dispatcher.Submit(ctx, jobs)
+		for i := range texts {
+			c, ok := resMap[customID(texts[i], prompts[i])]
+			switch {
+			case err != nil:
+				out[i] = llmOutcome{Err: err, Attempts: 1}
+			case !ok:
+				out[i] = llmOutcome{Err: withKind("batch_missing", errors.New("batch returned no result")), Attempts: 1}
+			case c.Failure != "":
+				out[i] = llmOutcome{Err: withKind("batch_"+c.Failure, fmt.Errorf("batch item %s", c.Failure)), Attempts: 1}
+			case c.Cached:
+				out[i] = llmOutcome{Value: c.Text, Cached: true, Logprob: c.Logprob}
+			default:
+				out[i] = llmOutcome{Value: c.Text, Attempts: 1, Logprob: c.Logprob}
+			}
+		}
+
+	default:
+		for i := range out {
+			out[i] = llmOutcome{Err: withKind("no_provider", errors.New("no LLM provider configured"))}
+		}
+	}
+
+	return out
+}
+
+// answerRetries is how often a row whose answer does not parse is asked again.
+const answerRetries = 1
+
+// parsedAnswer is the outcome of one row of tryParsed.
+type parsedAnswer struct {
+	Value   any      // as returned by parse
+	Err     error    // the last error, nil when Value is set
+	Logprob *float64 // of the accepted answer, see Completion.Logprob
+}
+
+// tryParsed answers texts[i]+prompts[i] like tryLLM, for answers that parse must accept. Rows
+// whose answer is rejected are asked again, up to answerRetries times, with the rejection
+// added to the prompt. It returns the parsed value or the last error of each row; rejected
+// answers have the error kind invalid_answer.
+func tryParsed(ds *dispatchSet, texts, prompts []string, parse func(answer string) (any, error)) []parsedAnswer {
+	out := make([]parsedAnswer, len(texts))
+
+	pending := make([]int, len(texts))
+	for i := range pending {
+		pending[i] = i
+	}
+
+	for attempt := 0; attempt <= answerRetries && len(pending) > 0; attempt++ {
+		ts := make([]string, len(pending))
+		ps := make([]string, len(pending))
+		for k, i := range pending {
+			ts[k], ps[k] = texts[i], prompts[i]
+			if out[i].Err != nil {
+				ps[k] += "\n\nA previous answer was rejected: " + out[i].Err.Error() + ". Follow the instruction exactly."
+			}
+		}
+
+		var rejected []int
+		for k, o := range tryLLM(ds, ts, ps) {
+			i := pending[k]
+			if o.Err != nil {
+				// failed requests were retried by the provider already
+				out[i].Err = o.Err
+				continue
+			}
+			v, err := parse(o.Value)
+			if err != nil {
+				out[i].Err = withKind("invalid_answer", fmt.Errorf("answer %q: %w", truncateChars(o.Value, 200), err))
+				rejected = append(rejected, i)
+				continue
+			}
+			out[i] = parsedAnswer{Value: v, Logprob: o.Logprob}
+		}
+		pending = rejected
+	}
+
+	return out
+}
+
+// parallelRows runs fn for 0..n-1 on GOMAXPROCS workers.
+func parallelRows(n int, fn func(i int)) {
+	jobCh := make(chan int, n)
+	for i := 0; i < n; i++ {
+		jobCh <- i
+	}
+	close(jobCh)
+
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.GOMAXPROCS(0); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobCh {
+				fn(i)
+			}
+		}()
+	}
+	wg.Wait()
+}
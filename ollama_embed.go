@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// OllamaEmbedder talks to a local Ollama server via /api/embed. No API key needed.
+type OllamaEmbedder struct {
+	httpClient *http.Client
+	host       string
+	timeout    time.Duration
+}
+
+type ollamaEmbedRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type ollamaEmbedResponse struct {
+	Embeddings [][]float32 `json:"embeddings"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// NewOllamaEmbedderFromEnv reads OLLAMA_HOST like the chat client.
+func NewOllamaEmbedderFromEnv() *OllamaEmbedder {
+	return &OllamaEmbedder{
+		httpClient: &http.Client{},
+		host:       ollamaHostFromEnv(),
+		// local models are slow to load on first use
+		timeout: 120 * time.Second,
+	}
+}
+
+func (o *OllamaEmbedder) Name() string { return "ollama" }
+
+func (o *OllamaEmbedder) Embed(ctx context.Context, model string, texts []string) ([][]float32, error) {
+	if o.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.timeout)
+		defer cancel()
+	}
+
+	payload, err := json.Marshal(ollamaEmbedRequest{Model: model, Input: texts})
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, o.host+"/api/embed", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := o.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("/api/embed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("/api/embed: %w", err)
+	}
+
+	var out ollamaEmbedResponse
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, fmt.Errorf("/api/embed: status=%d: %w", resp.StatusCode, err)
+	}
+	if out.Error != "" {
+		return nil, withKind("ollama_error", fmt.Errorf("/api/embed: ollama error: %s", out.Error))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, withKind("http_error", fmt.Errorf("/api/embed: status=%d", resp.StatusCode))
+	}
+	return out.Embeddings, nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const ollamaSystemPrompt = "you are a precise assistant. follow the instruction and respond with only the answer"
+
+// OllamaClient talks to a local Ollama server via /api/chat or /api/generate. No API key needed.
+type OllamaClient struct {
+	httpClient *http.Client
+	host       string
+	model      string
+	useChat    bool
+	maxTokens  int
+	timeout    time.Duration
+}
+
+type ollamaMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type ollamaOptions struct {
+	NumPredict  int      `json:"num_predict,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty"`
+}
+
+type ollamaChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+	Format   json.RawMessage `json:"format,omitempty"` // JSON schema of the answer
+	Options  ollamaOptions   `json:"options"`
+}
+
+type ollamaGenerateRequest struct {
+	Model   string          `json:"model"`
+	System  string          `json:"system,omitempty"`
+	Prompt  string          `json:"prompt"`
+	Stream  bool            `json:"stream"`
+	Format  json.RawMessage `json:"format,omitempty"` // JSON schema of the answer
+	Options ollamaOptions   `json:"options"`
+}
+
+type ollamaResponse struct {
+	Model           string         `json:"model"`
+	Message         *ollamaMessage `json:"message,omitempty"` // /api/chat
+	Response        string         `json:"response"`          // /api/generate
+	PromptEvalCount int            `json:"prompt_eval_count"`
+	EvalCount       int            `json:"eval_count"`
+	Error           string         `json:"error,omitempty"`
+}
+
+// NewOllamaClientFromEnv reads OLLAMA_HOST (default http://localhost:11434), OLLAMA_MODEL
+// and OLLAMA_API=chat|generate (default chat).
+func NewOllamaClientFromEnv() (*OllamaClient, error) {
+	host := ollamaHostFromEnv()
+
+	model := os.Getenv("OLLAMA_MODEL")
+	if model == "" {
+		return nil, fmt.Errorf("OLLAMA_MODEL is not set")
+	}
+
+	useChat := true
+	switch api := os.Getenv("OLLAMA_API"); api {
+	case "", "chat":
+	case "generate":
+		useChat = false
+	default:
+		return nil, fmt.Errorf("unknown OLLAMA_API %q", api)
+	}
+
+	return &OllamaClient{
+		httpClient: &http.Client{},
+		host:       host,
+		model:      model,
+		useChat:    useChat,
+		maxTokens:  256,
+		// local models are slow to load on first use
+		timeout: 120 * time.Second,
+	}, nil
+}
+
+// ollamaHostFromEnv reads OLLAMA_HOST, default http://localhost:11434.
+func ollamaHostFromEnv() string {
+	host := strings.TrimRight(os.Getenv("OLLAMA_HOST"), "/")
+	if host == "" {
+		host = "http://localhost:11434"
+	}
+	if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+	return host
+}
+
+func (o *OllamaClient) Name() string { return "ollama" }
+
+func (o *OllamaClient) DefaultModel() string { return o.model }
+
+func (o *OllamaClient) Capabilities() Capability { return 0 }
+
+func (o *OllamaClient) Complete(ctx context.Context, req CompletionRequest) (Completion, error) {
+	reqCtx := ctx
+
+	var cancel context.CancelFunc
+	if o.timeout > 0 {
+		reqCtx, cancel = context.WithTimeout(ctx, o.timeout)
+		defer cancel()
+	}
+
+	opts := ollamaOptions{NumPredict: req.maxTokensOr(o.maxTokens), Temperature: req.Temperature}
+
+	var format json.RawMessage
+	if req.Schema != "" {
+		format = json.RawMessage(req.Schema)
+	}
+
+	var (
+		path string
+		body any
+	)
+	if o.useChat {
+		messages := []ollamaMessage{{Role: "system", Content: req.systemOr(ollamaSystemPrompt)}}
+		for _, ex := range req.Examples {
+			messages = append(messages,
+				ollamaMessage{Role: "user", Content: renderUserMessage(ex.Input, req.Prompt)},
+				ollamaMessage{Role: "assistant", Content: ex.Output},
+			)
+		}
+
+		path = "/api/chat"
+		body = ollamaChatRequest{
+			Model:    req.modelOr(o.model),
+			Messages: append(messages, ollamaMessage{Role: "user", Content: renderUserMessage(req.Text, req.Prompt)}),
+			Format:   format,
+			Options:  opts,
+		}
+	} else {
+		// generate takes one prompt, so examples are written into it
+		path = "/api/generate"
+		body = ollamaGenerateRequest{
+			Model:   req.modelOr(o.model),
+			System:  req.systemOr(ollamaSystemPrompt),
+			Prompt:  renderExamplesPrompt(req),
+			Format:  format,
+			Options: opts,
+		}
+	}
+
+	out, err := o.post(reqCtx, path, body)
+	if err != nil {
+		return Completion{}, err
+	}
+
+	c := Completion{
+		Text:  out.Response,
+		Model: out.Model,
+		Usage: Usage{
+			InputTokens:  out.PromptEvalCount,
+			OutputTokens: out.EvalCount,
+		},
+	}
+	if out.Message != nil {
+		c.Text = out.Message.Content
+	}
+	return c, nil
+}
+
+func (o *OllamaClient) post(ctx context.Context, path string, body any) (*ollamaResponse, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, o.host+path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := o.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var out ollamaResponse
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, fmt.Errorf("%s: status=%d: %w", path, resp.StatusCode, err)
+	}
+	if out.Error != "" {
+		return nil, withKind("ollama_error", fmt.Errorf("%s: ollama error: %s", path, out.Error))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, withKind("http_error", fmt.Errorf("%s: status=%d", path, resp.StatusCode))
+	}
+
+	return &out, nil
+}
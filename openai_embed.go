@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// OpenAIEmbedder talks to any server implementing the OpenAI /v1/embeddings API.
+type OpenAIEmbedder struct {
+	httpClient *http.Client
+	baseURL    string
+	apiKey     string
+	timeout    time.Duration
+}
+
+type openAIEmbeddingRequest struct {
+	Model          string   `json:"model"`
+	Input          []string `json:"input"`
+	EncodingFormat string   `json:"encoding_format"`
+}
+
+type openAIEmbeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+	Error *openAIError `json:"error,omitempty"`
+}
+
+// NewOpenAIEmbedderFromEnv reads OPENAI_BASE_URL and OPENAI_API_KEY like the chat client.
+func NewOpenAIEmbedderFromEnv() *OpenAIEmbedder {
+	return &OpenAIEmbedder{
+		httpClient: &http.Client{},
+		baseURL:    openAIBaseURLFromEnv(),
+		apiKey:     os.Getenv("OPENAI_API_KEY"),
+		timeout:    60 * time.Second,
+	}
+}
+
+func (o *OpenAIEmbedder) Name() string { return "openai" }
+
+func (o *OpenAIEmbedder) Embed(ctx context.Context, model string, texts []string) ([][]float32, error) {
+	if o.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.timeout)
+		defer cancel()
+	}
+
+	body, err := json.Marshal(openAIEmbeddingRequest{Model: model, Input: texts, EncodingFormat: "float"})
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, o.baseURL+"/embeddings", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if o.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+o.apiKey)
+	}
+
+	resp, err := o.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("Embeddings: %w", err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Embeddings: %w", err)
+	}
+
+	var out openAIEmbeddingResponse
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, fmt.Errorf("Embeddings: status=%d: %w", resp.StatusCode, err)
+	}
+	if out.Error != nil {
+		return nil, withKind(out.Error.Type, fmt.Errorf("Embeddings: openai error type=%s message=%s", out.Error.Type, out.Error.Message))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, withKind("http_error", fmt.Errorf("Embeddings: status=%d", resp.StatusCode))
+	}
+
+	// the data is in input order on OpenAI, but index is what the API promises
+	vectors := make([][]float32, len(texts))
+	for _, d := range out.Data {
+		if d.Index < 0 || d.Index >= len(texts) {
+			return nil, fmt.Errorf("Embeddings: index %d out of range", d.Index)
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	return vectors, nil
+}
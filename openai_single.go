@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// OpenAIClient talks to any server implementing the OpenAI /v1/chat/completions API
+// (OpenAI, vLLM, llama.cpp server, LM Studio, ...).
+type OpenAIClient struct {
+	httpClient *http.Client
+	baseURL    string
+	apiKey     string
+	model      string
+	maxTokens  int
+	timeout    time.Duration
+}
+
+type openAIChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type openAIChatRequest struct {
+	Model       string              `json:"model"`
+	Messages    []openAIChatMessage `json:"messages"`
+	MaxTokens   int                 `json:"max_tokens,omitempty"`
+	Temperature *float64            `json:"temperature,omitempty"`
+	Logprobs    bool                `json:"logprobs,omitempty"`
+
+	ResponseFormat *openAIResponseFormat `json:"response_format,omitempty"`
+}
+
+// openAIResponseFormat asks for structured output conforming to a JSON schema.
+type openAIResponseFormat struct {
+	Type       string `json:"type"` // json_schema
+	JSONSchema struct {
+		Name   string          `json:"name"`
+		Schema json.RawMessage `json:"schema"`
+		Strict bool            `json:"strict"`
+	} `json:"json_schema"`
+}
+
+type openAIChatResponse struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Message  openAIChatMessage `json:"message"`
+		Logprobs *struct {
+			Content []struct {
+				Logprob float64 `json:"logprob"`
+			} `json:"content"`
+		} `json:"logprobs"`
+	} `json:"choices"`
+	Usage struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+	Error *openAIError `json:"error,omitempty"`
+}
+
+type openAIError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// NewOpenAIClientFromEnv reads OPENAI_BASE_URL (default https://api.openai.com/v1),
+// OPENAI_API_KEY (optional for local servers) and OPENAI_MODEL.
+func NewOpenAIClientFromEnv() (*OpenAIClient, error) {
+	baseURL := openAIBaseURLFromEnv()
+
+	model := os.Getenv("OPENAI_MODEL")
+	if model == "" {
+		return nil, fmt.Errorf("OPENAI_MODEL is not set")
+	}
+
+	return &OpenAIClient{
+		httpClient: &http.Client{},
+		baseURL:    baseURL,
+		apiKey:     os.Getenv("OPENAI_API_KEY"),
+		model:      model,
+		maxTokens:  256,
+		timeout:    20 * time.Second,
+	}, nil
+}
+
+// openAIBaseURLFromEnv reads OPENAI_BASE_URL, default https://api.openai.com/v1.
+func openAIBaseURLFromEnv() string {
+	baseURL := strings.TrimRight(os.Getenv("OPENAI_BASE_URL"), "/")
+	if baseURL == "" {
+		baseURL = "https://api.openai.com/v1"
+	}
+	return baseURL
+}
+
+func (o *OpenAIClient) Name() string { return "openai" }
+
+func (o *OpenAIClient) DefaultModel() string { return o.model }
+
+func (o *OpenAIClient) Capabilities() Capability { return 0 }
+
+func (o *OpenAIClient) Complete(ctx context.Context, req CompletionRequest) (Completion, error) {
+	reqCtx := ctx
+
+	var cancel context.CancelFunc
+	if o.timeout > 0 {
+		reqCtx, cancel = context.WithTimeout(ctx, o.timeout)
+		defer cancel()
+	}
+
+	messages := []openAIChatMessage{
+		{Role: "system", Content: req.systemOr("you are a precise assistant. follow the instruction and respond with only the answer")},
+	}
+	for _, ex := range req.Examples {
+		messages = append(messages,
+			openAIChatMessage{Role: "user", Content: renderUserMessage(ex.Input, req.Prompt)},
+			openAIChatMessage{Role: "assistant", Content: ex.Output},
+		)
+	}
+
+	chat := openAIChatRequest{
+		Model:       req.modelOr(o.model),
+		Messages:    append(messages, openAIChatMessage{Role: "user", Content: renderUserMessage(req.Text, req.Prompt)}),
+		MaxTokens:   req.maxTokensOr(o.maxTokens),
+		Temperature: req.Temperature,
+		Logprobs:    req.Logprobs,
+	}
+	if req.Schema != "" {
+		chat.ResponseFormat = &openAIResponseFormat{Type: "json_schema"}
+		chat.ResponseFormat.JSONSchema.Name = "answer"
+		chat.ResponseFormat.JSONSchema.Schema = json.RawMessage(req.Schema)
+		chat.ResponseFormat.JSONSchema.Strict = true
+	}
+
+	body, err := json.Marshal(chat)
+	if err != nil {
+		return Completion{}, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(reqCtx, http.MethodPost, o.baseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return Completion{}, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if o.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+o.apiKey)
+	}
+
+	resp, err := o.httpClient.Do(httpReq)
+	if err != nil {
+		return Completion{}, fmt.Errorf("ChatCompletions: %w", err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Completion{}, fmt.Errorf("ChatCompletions: %w", err)
+	}
+
+	var out openAIChatResponse
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return Completion{}, fmt.Errorf("ChatCompletions: status=%d: %w", resp.StatusCode, err)
+	}
+	if out.Error != nil {
+		return Completion{}, withKind(out.Error.Type, fmt.Errorf("ChatCompletions: openai error type=%s message=%s", out.Error.Type, out.Error.Message))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Completion{}, withKind("http_error", fmt.Errorf("ChatCompletions: status=%d", resp.StatusCode))
+	}
+
+	c := Completion{
+		Model: out.Model,
+		Usage: Usage{
+			InputTokens:  out.Usage.PromptTokens,
+			OutputTokens: out.Usage.CompletionTokens,
+		},
+	}
+	if len(out.Choices) > 0 {
+		c.Text = out.Choices[0].Message.Content
+		// servers without logprobs support leave them out
+		if lp := out.Choices[0].Logprobs; req.Logprobs && lp != nil && len(lp.Content) > 0 {
+			var sum float64
+			for _, t := range lp.Content {
+				sum += t.Logprob
+			}
+			c.Logprob = &sum
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,219 @@
+// Package openaitest provides a local fake of the OpenAI chat completions API for end-to-end
+// tests, in the spirit of net/http/httptest.
+//
+//	srv := openaitest.NewServer("test")
+//	defer srv.Close()
+//	os.Setenv("OPENAI_BASE_URL", srv.BaseURL())
+//	os.Setenv("OPENAI_API_KEY", "test")
+//
+// Requests are checked like the API does: the bearer key, the model and the messages. Queued
+// API errors (429, 500, ...) are scripted through FailNext. cmd/fakeopenai serves one for the
+// end-to-end tests of the Makefile.
+package openaitest
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+// Message is a chat message of a request.
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Request is the part of a chat completions request the fake reads.
+type Request struct {
+	Model       string    `json:"model"`
+	Messages    []Message `json:"messages"`
+	MaxTokens   *int      `json:"max_tokens"`
+	Temperature *float64  `json:"temperature"`
+	Logprobs    bool      `json:"logprobs"`
+
+	ResponseFormat *struct {
+		Type string `json:"type"`
+	} `json:"response_format"`
+}
+
+// AnswerFunc renders the reply text for a request.
+type AnswerFunc func(req Request) string
+
+type scriptedError struct {
+	status  int
+	errType string
+}
+
+// Server is a fake OpenAI API. All setters are safe for concurrent use.
+type Server struct {
+	*httptest.Server
+
+	key string
+
+	mu sync.Mutex
+
+	answer   AnswerFunc
+	errs     []scriptedError
+	messages int
+}
+
+// NewServer starts a fake API that accepts requests with the bearer key, or without one
+// when key is empty, like a local server. By default every request is answered with a
+// deterministic "answer-<hash>" text, or "{}" when it asks for a JSON schema.
+func NewServer(key string) *Server {
+	s := &Server{key: key, answer: defaultAnswer}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/chat/completions", s.handleChatCompletions)
+
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// BaseURL is the value for OPENAI_BASE_URL.
+func (s *Server) BaseURL() string {
+	return s.URL + "/v1"
+}
+
+// SetAnswer replaces the function that renders reply texts.
+func (s *Server) SetAnswer(f AnswerFunc) {
+	s.mu.Lock()
+	s.answer = f
+	s.mu.Unlock()
+}
+
+// FailNext makes the next n requests fail with the given HTTP status and OpenAI error type,
+// e.g. FailNext(2, 429, "rate_limit_exceeded") or FailNext(1, 500, "server_error").
+func (s *Server) FailNext(n, status int, errType string) {
+	s.mu.Lock()
+	for i := 0; i < n; i++ {
+		s.errs = append(s.errs, scriptedError{status: status, errType: errType})
+	}
+	s.mu.Unlock()
+}
+
+// MessageCount is the number of successful chat completions.
+func (s *Server) MessageCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.messages
+}
+
+func (s *Server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
+	if s.key != "" && r.Header.Get("Authorization") != "Bearer "+s.key {
+		writeError(w, http.StatusUnauthorized, "invalid_request_error", "Incorrect API key provided.")
+		return
+	}
+
+	s.mu.Lock()
+	var e *scriptedError
+	if len(s.errs) > 0 {
+		e = &s.errs[0]
+		s.errs = s.errs[1:]
+	}
+	s.mu.Unlock()
+
+	if e != nil {
+		writeError(w, e.status, e.errType, fmt.Sprintf("scripted %s", e.errType))
+		return
+	}
+
+	var req Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	if msg := validate(req); msg != "" {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", msg)
+		return
+	}
+
+	s.mu.Lock()
+	s.messages++
+	id := s.messages
+	answer := s.answer
+	s.mu.Unlock()
+
+	text := answer(req)
+	choice := map[string]any{
+		"index":         0,
+		"message":       map[string]string{"role": "assistant", "content": text},
+		"finish_reason": "stop",
+	}
+	if req.Logprobs {
+		choice["logprobs"] = map[string]any{
+			"content": []map[string]any{{"token": text, "logprob": -0.25}},
+		}
+	}
+
+	in := 0
+	for _, m := range req.Messages {
+		in += len(m.Content)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"id":      fmt.Sprintf("chatcmpl-%d", id),
+		"object":  "chat.completion",
+		"model":   req.Model,
+		"choices": []map[string]any{choice},
+		"usage": map[string]int{
+			"prompt_tokens":     (in + 3) / 4,
+			"completion_tokens": (len(text) + 3) / 4,
+			"total_tokens":      (in+3)/4 + (len(text)+3)/4,
+		},
+	})
+}
+
+func validate(req Request) string {
+	switch {
+	case req.Model == "":
+		return "you must provide a model parameter"
+	case len(req.Messages) == 0:
+		return "messages: must not be empty"
+	case req.MaxTokens != nil && *req.MaxTokens <= 0:
+		return "max_tokens: must be positive"
+	case req.Temperature != nil && (*req.Temperature < 0 || *req.Temperature > 2):
+		return "temperature: must be between 0 and 2"
+	case req.Messages[len(req.Messages)-1].Role != "user":
+		return "messages: the last message must be from the user"
+	}
+	for i, m := range req.Messages {
+		switch m.Role {
+		case "system", "user", "assistant":
+		default:
+			return fmt.Sprintf("messages[%d].role: unknown role %q", i, m.Role)
+		}
+	}
+	if rf := req.ResponseFormat; rf != nil && rf.Type != "json_schema" && rf.Type != "json_object" && rf.Type != "text" {
+		return fmt.Sprintf("response_format.type: unknown type %q", rf.Type)
+	}
+	return ""
+}
+
+func defaultAnswer(req Request) string {
+	if req.ResponseFormat != nil && req.ResponseFormat.Type == "json_schema" {
+		return "{}"
+	}
+	var sb strings.Builder
+	for _, m := range req.Messages {
+		sb.WriteString(m.Content)
+	}
+	sum := sha1.Sum([]byte(sb.String()))
+	return "answer-" + hex.EncodeToString(sum[:4])
+}
+
+func writeError(w http.ResponseWriter, status int, errType, message string) {
+	writeJSON(w, status, map[string]any{
+		"error": map[string]any{"message": message, "type": errType, "param": nil, "code": nil},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// libraryPrompt is one version of a prompt registered with ai_prompt_register, and one line
+// of the library file.
+type libraryPrompt struct {
+	Name         string    `json:"name"`
+	Version      int       `json:"version"`
+	Prompt       string    `json:"prompt"`
+	RegisteredAt time.Time `json:"registered_at"`
+}
+
+// ref is the reference that resolves to p, e.g. sentiment@2.
+func (p libraryPrompt) ref() string {
+	return p.Name + "@" + strconv.Itoa(p.Version)
+}
+
+// promptLibrary keeps the registered prompts by name, versions in ascending order. Versions
+// are immutable: registering a version again only succeeds with the same text. With a path
+// every registration is appended to that JSON lines file, so the library outlives the process.
+type promptLibrary struct {
+	mu      sync.Mutex
+	prompts map[string][]libraryPrompt
+	file    *os.File
+}
+
+func newPromptLibrary(path string) (*promptLibrary, error) {
+	l := &promptLibrary{prompts: make(map[string][]libraryPrompt)}
+	if path == "" {
+		return l, nil
+	}
+
+	if err := l.load(path); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open prompt library: %w", err)
+	}
+	l.file = f
+
+	return l, nil
+}
+
+func (l *promptLibrary) load(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open prompt library: %w", err)
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var p libraryPrompt
+		if err := json.Unmarshal(sc.Bytes(), &p); err != nil {
+			return fmt.Errorf("prompt library %s:%d: %w", path, line, err)
+		}
+		if err := l.add(p); err != nil {
+			return fmt.Errorf("prompt library %s:%d: %w", path, line, err)
+		}
+	}
+	return sc.Err()
+}
+
+// add inserts p in version order; an existing version with the same text is left as is.
+func (l *promptLibrary) add(p libraryPrompt) error {
+	list := l.prompts[p.Name]
+	i := sort.Search(len(list), func(i int) bool { return list[i].Version >= p.Version })
+	if i < len(list) && list[i].Version == p.Version {
+		if list[i].Prompt != p.Prompt {
+			return fmt.Errorf("prompt %s is already registered with a different text, register a new version", p.ref())
+		}
+		return nil
+	}
+	l.prompts[p.Name] = append(list[:i], append([]libraryPrompt{p}, list[i:]...)...)
+	return nil
+}
+
+// register adds version of prompt name. Version 0 registers the version after the latest,
+// unless the latest has the same text, so re-running a setup script adds no versions.
+func (l *promptLibrary) register(name, prompt string, version int) (libraryPrompt, error) {
+	if !isIdentifier(name) {
+		return libraryPrompt{}, fmt.Errorf("%q is not a prompt name, use letters, digits and _", name)
+	}
+	if prompt == "" {
+		return libraryPrompt{}, errors.New("prompt must not be empty")
+	}
+	if version < 0 {
+		return libraryPrompt{}, fmt.Errorf("version %d is not positive", version)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	list := l.prompts[name]
+	if version == 0 {
+		version = 1
+		if len(list) > 0 {
+			latest := list[len(list)-1]
+			if latest.Prompt == prompt {
+				return latest, nil
+			}
+			version = latest.Version + 1
+		}
+	}
+	for _, p := range list {
+		if p.Version == version && p.Prompt == prompt {
+			return p, nil
+		}
+	}
+
+	p := libraryPrompt{Name: name, Version: version, Prompt: prompt, RegisteredAt: time.Now().UTC()}
+	if err := l.add(p); err != nil {
+		return libraryPrompt{}, err
+	}
+
+	if l.file != nil {
+		b, err := json.Marshal(p)
+		if err == nil {
+			_, err = l.file.Write(append(b, '\n'))
+		}
+		if err != nil {
+			return libraryPrompt{}, fmt.Errorf("write prompt library: %w", err)
+		}
+	}
+	return p, nil
+}
+
+// resolve returns the prompt of ref, either name@version or name for the latest version.
+func (l *promptLibrary) resolve(ref string) (libraryPrompt, error) {
+	name, ver, hasVer := strings.Cut(strings.TrimSpace(ref), "@")
+	version := 0
+	if hasVer {
+		n, err := strconv.Atoi(ver)
+		if err != nil || n <= 0 {
+			return libraryPrompt{}, fmt.Errorf("prompt_ref %q: %q is not a version", ref, ver)
+		}
+		version = n
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	list := l.prompts[name]
+	if len(list) == 0 {
+		return libraryPrompt{}, fmt.Errorf("prompt_ref %q: no prompt %q, register it with ai_prompt_register", ref, name)
+	}
+	if version == 0 {
+		return list[len(list)-1], nil
+	}
+	for _, p := range list {
+		if p.Version == version {
+			return p, nil
+		}
+	}
+	return libraryPrompt{}, fmt.Errorf("prompt_ref %q: %s has no version %d, latest is %d", ref, name, version, list[len(list)-1].Version)
+}
+
+// list returns every version of every prompt, by name and version.
+func (l *promptLibrary) list() []libraryPrompt {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	names := make([]string, 0, len(l.prompts))
+	for name := range l.prompts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var out []libraryPrompt
+	for _, name := range names {
+		out = append(out, l.prompts[name]...)
+	}
+	return out
+}
+
+var (
+	promptLibOnce sync.Once
+	promptLib     *promptLibrary
+	promptLibErr  error
+)
+
+// promptLibraryFromEnv returns the process-wide prompt library, kept in the file
+// QUACK_PROMPT_LIBRARY or only in memory when that is unset.
+func promptLibraryFromEnv() (*promptLibrary, error) {
+	promptLibOnce.Do(func() {
+		promptLib, promptLibErr = newPromptLibrary(os.Getenv("QUACK_PROMPT_LIBRARY"))
+	})
+	return promptLib, promptLibErr
+}
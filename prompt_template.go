@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// promptTemplate is a parsed ai_prompt template: literal text with {name} placeholders.
+// {{ and }} are literal braces, and {name:N} keeps at most N characters of the value.
+type promptTemplate struct {
+	parts []templatePart
+}
+
+// templatePart is literal text, or a placeholder when field is set.
+type templatePart struct {
+	literal string
+	field   string
+	limit   int // 0 means no limit
+}
+
+// truncationMark ends values cut by a {name:N} limit.
+const truncationMark = "..."
+
+func parsePromptTemplate(s string) (*promptTemplate, error) {
+	t := &promptTemplate{}
+	var lit strings.Builder
+
+	flush := func() {
+		if lit.Len() > 0 {
+			t.parts = append(t.parts, templatePart{literal: lit.String()})
+			lit.Reset()
+		}
+	}
+
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '{' && strings.HasPrefix(s[i:], "{{"):
+			lit.WriteByte('{')
+			i++
+
+		case c == '}' && strings.HasPrefix(s[i:], "}}"):
+			lit.WriteByte('}')
+			i++
+
+		case c == '}':
+			return nil, fmt.Errorf("unmatched } at %d, write }} for a literal brace", i)
+
+		case c == '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated placeholder at %d, write {{ for a literal brace", i)
+			}
+			p, err := parsePlaceholder(s[i+1 : i+end])
+			if err != nil {
+				return nil, fmt.Errorf("placeholder at %d: %w", i, err)
+			}
+			flush()
+			t.parts = append(t.parts, p)
+			i += end
+
+		default:
+			lit.WriteByte(c)
+		}
+	}
+	flush()
+
+	return t, nil
+}
+
+// parsePlaceholder parses the inside of {name} or {name:N}.
+func parsePlaceholder(s string) (templatePart, error) {
+	name, limit, hasLimit := strings.Cut(s, ":")
+
+	if !isIdentifier(name) {
+		return templatePart{}, fmt.Errorf("%q is not a field name", name)
+	}
+
+	p := templatePart{field: name}
+	if hasLimit {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return templatePart{}, fmt.Errorf("%q is not a positive length", limit)
+		}
+		p.limit = n
+	}
+	return p, nil
+}
+
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		switch {
+		case r == '_', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case i > 0 && r >= '0' && r <= '9':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// fields returns the placeholders of t in order of first use.
+func (t *promptTemplate) fields() []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, p := range t.parts {
+		if p.field != "" && !seen[p.field] {
+			seen[p.field] = true
+			out = append(out, p.field)
+		}
+	}
+	return out
+}
+
+// check reports the first placeholder that is not among fields.
+func (t *promptTemplate) check(fields []string) error {
+	for _, f := range t.fields() {
+		if !containsFold(fields, f) {
+			return fmt.Errorf("no field %q, have %s", f, strings.Join(fields, ", "))
+		}
+	}
+	return nil
+}
+
+// render fills the placeholders with value(name); values are inserted as they are and never
+// parsed as template text. maxChars > 0 limits the length of the result.
+func (t *promptTemplate) render(value func(name string) string, maxChars int) (string, error) {
+	var b strings.Builder
+	for _, p := range t.parts {
+		if p.field == "" {
+			b.WriteString(p.literal)
+			continue
+		}
+		b.WriteString(truncateChars(value(p.field), p.limit))
+	}
+
+	out := b.String()
+	if maxChars > 0 && utf8.RuneCountInString(out) > maxChars {
+		return "", errors.New("prompt has more than " + strconv.Itoa(maxChars) + " characters, limit fields with {name:N}")
+	}
+	return out, nil
+}
+
+// truncateChars cuts s to limit characters including the truncation mark; 0 keeps s.
+func truncateChars(s string, limit int) string {
+	if limit <= 0 || utf8.RuneCountInString(s) <= limit {
+		return s
+	}
+
+	keep := limit - utf8.RuneCountInString(truncationMark)
+	if keep <= 0 {
+		return string([]rune(s)[:limit])
+	}
+	return string([]rune(s)[:keep]) + truncationMark
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Capability flags advertised by a Provider.
+type Capability uint32
+
+const (
+	// CapBatch means the provider implements BatchProvider.
+	CapBatch Capability = 1 << iota
+)
+
+func (c Capability) Has(f Capability) bool {
+	return c&f == f
+}
+
+// CompletionRequest is one text+prompt pair sent to a provider.
+type CompletionRequest struct {
+	// CustomID identifies the request inside a batch; unused for single completions.
+	CustomID string `json:"custom_id,omitempty"`
+	Text     string `json:"text"`
+	Prompt   string `json:"prompt"`
+
+	// Model, MaxTokens, Temperature and System override the provider defaults when set.
+	Model       string   `json:"model,omitempty"`
+	MaxTokens   int      `json:"max_tokens,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty"`
+	System      string   `json:"system,omitempty"`
+
+	// PromptRef names the library prompt, e.g. sentiment@2, when Prompt came from
+	// ai_prompt_register. Providers ignore it; it only keeps ids and cache keys apart.
+	PromptRef string `json:"prompt_ref,omitempty"`
+
+	// Examples are sent as earlier exchanges, each asked with Prompt, before Text.
+	Examples []Example `json:"examples,omitempty"`
+
+	// Schema is a JSON schema of an object. When set, providers constrain the answer to it
+	// (tool use, JSON mode) and return the object as JSON text.
+	Schema string `json:"schema,omitempty"`
+
+	// Logprobs asks for the log probability of the answer. Providers that cannot report it
+	// ignore it and leave Completion.Logprob unset.
+	Logprobs bool `json:"logprobs,omitempty"`
+}
+
+// Example is a few-shot example: an input and the answer expected for it.
+type Example struct {
+	Input  string `json:"input"`
+	Output string `json:"output"`
+}
+
+// systemOr returns req.System, or def when unset.
+func (req CompletionRequest) systemOr(def string) string {
+	if req.System != "" {
+		return req.System
+	}
+	return def
+}
+
+// modelOr returns req.Model, or def when unset.
+func (req CompletionRequest) modelOr(def string) string {
+	if req.Model != "" {
+		return req.Model
+	}
+	return def
+}
+
+// paramsKey identifies the overrides of req in ids and cache keys; "" when none are set.
+func (req CompletionRequest) paramsKey() string {
+	if req.Model == "" && req.MaxTokens == 0 && req.Temperature == nil && req.System == "" && req.PromptRef == "" && len(req.Examples) == 0 && req.Schema == "" && !req.Logprobs {
+		return ""
+	}
+	temp := "-"
+	if req.Temperature != nil {
+		temp = strconv.FormatFloat(*req.Temperature, 'g', -1, 64)
+	}
+	key := fmt.Sprintf("%s\x00%d\x00%s\x00%s\x00%s\x00%s\x00%s", req.Model, req.MaxTokens, temp, req.System, req.PromptRef, examplesKey(req.Examples), req.Schema)
+	// appended only when set, so recorded keys of requests without it stay valid
+	if req.Logprobs {
+		key += "\x00logprobs"
+	}
+	return key
+}
+
+// maxTokensOr returns req.MaxTokens, or def when unset.
+func (req CompletionRequest) maxTokensOr(def int) int {
+	if req.MaxTokens > 0 {
+		return req.MaxTokens
+	}
+	return def
+}
+
+// Completion is a provider's answer to one CompletionRequest.
+type Completion struct {
+	Text  string `json:"text"`
+	Model string `json:"model,omitempty"`
+	Usage Usage  `json:"usage"`
+
+	// Failure is the result type of a failed batch item, e.g. "errored" or "expired".
+	Failure string `json:"failure,omitempty"`
+
+	// Logprob is the summed log probability of the answer's tokens, when requested with
+	// CompletionRequest.Logprobs and reported by the provider.
+	Logprob *float64 `json:"logprob,omitempty"`
+
+	// Cached is set when the answer came from the response cache instead of the provider.
+	Cached bool `json:"-"`
+}
+
+// Usage is the token accounting reported by the provider, zero when unknown.
+type Usage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// Provider is an LLM backend usable by the single and fused paths.
+type Provider interface {
+	Name() string
+	Capabilities() Capability
+	Complete(ctx context.Context, req CompletionRequest) (Completion, error)
+}
+
+// BatchProvider is a Provider that can also submit many requests at once and poll for
+// the results. Results are keyed by CompletionRequest.CustomID; failed items have empty text
+// and Failure set.
+type BatchProvider interface {
+	Provider
+	RunBatch(ctx context.Context, reqs []CompletionRequest, pollEvery, pollTimeout time.Duration) (map[string]Completion, error)
+}
+
+// llmError is an error with a short machine-readable kind, e.g. the API error type
+// "rate_limit_error", "parse_mismatch" or "batch_expired". ai_llm_try reports the kind.
+type llmError struct {
+	kind string
+	err  error
+}
+
+func (e *llmError) Error() string { return e.err.Error() }
+
+func (e *llmError) Unwrap() error { return e.err }
+
+func withKind(kind string, err error) error {
+	return &llmError{kind: kind, err: err}
+}
+
+// errorKind classifies err; nil has no kind.
+func errorKind(err error) string {
+	var le *llmError
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &le):
+		return le.kind
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	default:
+		return "error"
+	}
+}
+
+// llmOutcome is everything known about one answer; ai_llm_try returns it per row.
+type llmOutcome struct {
+	Value    string
+	Err      error
+	Attempts int      // requests made, including retries; 0 when cached
+	Cached   bool     // answered from a dispatcher cache or the response cache
+	Logprob  *float64 // see Completion.Logprob
+}
+
+type attemptsKey struct{}
+
+// withAttemptCounter returns a context in which retryProvider counts its attempts into n.
+func withAttemptCounter(ctx context.Context) (context.Context, *atomic.Int32) {
+	n := new(atomic.Int32)
+	return context.WithValue(ctx, attemptsKey{}, n), n
+}
+
+// attemptsMade reads a counter from withAttemptCounter; providers without retries make one.
+func attemptsMade(n *atomic.Int32) int {
+	return max(1, int(n.Load()))
+}
+
+// renderUserMessage is the user turn every provider sends for a text+prompt pair.
+func renderUserMessage(text, prompt string) string {
+	return fmt.Sprintf(
+		"TEXT:\n%s\n\nINSTRUCTION:\n%s\n\nReturn only the answer text.",
+		text, prompt,
+	)
+}
+
+// renderExamplesPrompt is the user message of req with its examples written out before it,
+// for APIs that take a single prompt instead of a list of messages.
+func renderExamplesPrompt(req CompletionRequest) string {
+	var b strings.Builder
+	for _, ex := range req.Examples {
+		b.WriteString(renderUserMessage(ex.Input, req.Prompt))
+		b.WriteString("\n\nANSWER:\n")
+		b.WriteString(ex.Output)
+		b.WriteString("\n\n")
+	}
+	b.WriteString(renderUserMessage(req.Text, req.Prompt))
+	return b.String()
+}
+
+// missingKeyError is returned for a provider whose API key is not set. The extension loads
+// without one, since the key may still come from a quackai secret.
+type missingKeyError string
+
+func (e missingKeyError) Error() string { return string(e) + " is not set" }
+
+// isMissingKey reports whether err is, or wraps, a missingKeyError.
+func isMissingKey(err error) bool {
+	var mk missingKeyError
+	return errors.As(err, &mk)
+}
+
+// newProviderFromEnv builds the provider selected by QUACK_LLM_PROVIDER (default: anthropic)
+// for the given QUACK_LLM_MODE, wrapped in a cassette when QUACK_CASSETTE is set. A non-empty
+// apiKey, e.g. from a quackai secret, replaces the key from the environment.
+func newProviderFromEnv(mode, apiKey string) (Provider, error) {
+	path := os.Getenv("QUACK_CASSETTE")
+	if path == "" {
+		return newBaseProviderFromEnv(mode, apiKey)
+	}
+
+	cmode := cassetteMode(os.Getenv("QUACK_CASSETTE_MODE"))
+
+	// replaying needs no backend (and no API key)
+	var inner Provider
+	if cmode != cassetteReplay {
+		p, err := newBaseProviderFromEnv(mode, apiKey)
+		if err != nil {
+			return nil, err
+		}
+		inner = p
+	}
+
+	return NewCassetteProvider(path, cmode, inner)
+}
+
+func newBaseProviderFromEnv(mode, apiKey string) (Provider, error) {
+	name := os.Getenv("QUACK_LLM_PROVIDER")
+
+	switch name {
+	case "", "anthropic":
+		if apiKey == "" {
+			apiKey = os.Getenv("ANTHROPIC_API_KEY")
+		}
+		if mode == "single" || mode == "fused" {
+			c, err := NewAnthropicSingleClient(apiKey)
+			if err != nil {
+				return nil, err
+			}
+			return c, nil
+		}
+		c, err := NewAnthropicBatchClient(apiKey)
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+
+	case "openai":
+		c, err := NewOpenAIClientFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		if apiKey != "" {
+			c.apiKey = apiKey
+		}
+		return c, nil
+
+	case "ollama":
+		c, err := NewOllamaClientFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+
+	case "mock":
+		c, err := NewMockProviderFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+
+	default:
+		return nil, fmt.Errorf("unknown provider %q", name)
+	}
+}
+
+// setupDispatch resets the package-level dispatch state and wires p into the path for mode,
+// configured from the environment. An unset mode means batch, or single for providers without
+// batch support.
+func setupDispatch(mode string, p Provider) error {
+	dispatchMu.Lock()
+	defer dispatchMu.Unlock()
+
+	defaultDispatch = &dispatchSet{}
+	dispatchSets = map[dispatchConfig]*dispatchSet{}
+	dispatchUsed = map[dispatchConfig]uint64{}
+	providers = map[providerKey]Provider{}
+	limiters = map[limiterKey]*rate.Limiter{}
+
+	cfg := dispatchConfigFromEnv()
+	cfg.Mode = mode
+
+	ds, err := newDispatchSet(cfg, p)
+	if err != nil {
+		return err
+	}
+
+	defaultDispatch, defaultConfig = ds, cfg
+	addDispatchSet(cfg, ds)
+	providers[providerKey{mode: mode}] = p
+	return nil
+}
+
+// currentDispatch returns defaultDispatch, which setupDispatch replaces under dispatchMu.
+func currentDispatch() *dispatchSet {
+	dispatchMu.Lock()
+	defer dispatchMu.Unlock()
+	return defaultDispatch
+}
+
+// retryProvider retries failed completions with capped exponential backoff.
+type retryProvider struct {
+	Provider
+	retries int
+	backoff time.Duration
+}
+
+func (r *retryProvider) Complete(ctx context.Context, req CompletionRequest) (Completion, error) {
+	var lastErr error
+
+	n, _ := ctx.Value(attemptsKey{}).(*atomic.Int32)
+
+	for attempt := 0; attempt <= r.retries; attempt++ {
+		if n != nil {
+			n.Add(1)
+		}
+		c, err := r.Provider.Complete(ctx, req)
+		if err == nil {
+			return c, nil
+		}
+		lastErr = err
+
+		if attempt < r.retries {
+			sleepTime := r.backoff << attempt
+			if sleepTime > 500*time.Millisecond {
+				sleepTime = 500 * time.Millisecond
+			}
+			select {
+			case <-time.After(sleepTime):
+			case <-ctx.Done():
+				return Completion{}, ctx.Err()
+			}
+		}
+	}
+
+	return Completion{}, lastErr
+}
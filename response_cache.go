@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// responseCacheEntry is one line of the response cache file. Texts are only kept as a hash
+// inside the key.
+type responseCacheEntry struct {
+	Key      string     `json:"key"`
+	Response Completion `json:"response"`
+	CachedAt time.Time  `json:"cached_at"`
+}
+
+// responseCache keeps the answers of successful requests in a JSON lines file, so a query
+// that ran before is answered without calling the provider, also after a restart and from
+// every session. It is read once and appended to; when a key occurs twice the later line wins.
+type responseCache struct {
+	mu      sync.Mutex
+	entries map[string]Completion
+	file    *os.File
+}
+
+func newResponseCache(path string) (*responseCache, error) {
+	c := &responseCache{entries: make(map[string]Completion)}
+	if err := c.load(path); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open response cache: %w", err)
+	}
+	c.file = f
+
+	return c, nil
+}
+
+func (c *responseCache) load(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open response cache: %w", err)
+	}
+	defer f.Close()
+
+	r := bufio.NewReaderSize(f, 64*1024)
+	var off int64 // end of the last complete line
+	for line := 1; ; line++ {
+		b, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(b) == 0 {
+				return nil
+			}
+			// a process died while writing this line; drop it, so appends start on a line
+			// of their own
+			fmt.Fprintf(os.Stderr, "quack: response cache %s:%d: dropping incomplete line\n", path, line)
+			if err := os.Truncate(path, off); err != nil {
+				return fmt.Errorf("response cache %s: %w", path, err)
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("response cache %s: %w", path, err)
+		}
+		off += int64(len(b))
+
+		b = bytes.TrimSpace(b)
+		if len(b) == 0 {
+			continue
+		}
+		var e responseCacheEntry
+		if err := json.Unmarshal(b, &e); err != nil {
+			return fmt.Errorf("response cache %s:%d: %w", path, line, err)
+		}
+		c.entries[e.Key] = e.Response
+	}
+}
+
+// responseCacheKey identifies req to provider scope, the provider's name and default model:
+// the model, the call parameters including the system prompt, the prompt and a hash of the
+// text.
+func responseCacheKey(scope string, req CompletionRequest) string {
+	text := sha256.Sum256([]byte(req.Text))
+	h := sha256.New()
+	for _, part := range []string{scope, req.Model, req.paramsKey(), req.Prompt, hex.EncodeToString(text[:])} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *responseCache) get(key string) (Completion, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	comp, ok := c.entries[key]
+	return comp, ok
+}
+
+// put caches comp under key. A failed write only loses the entry for later processes.
+func (c *responseCache) put(key string, comp Completion) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[key] = comp
+	b, err := json.Marshal(responseCacheEntry{Key: key, Response: comp, CachedAt: time.Now().UTC()})
+	if err == nil {
+		_, err = c.file.Write(append(b, '\n'))
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "quack: write response cache: %v\n", err)
+	}
+}
+
+var (
+	responseCacheOnce sync.Once
+	responseCacheInst *responseCache
+	responseCacheErr  error
+)
+
+// responseCacheFromEnv returns the process-wide response cache in the file QUACK_CACHE, or
+// nil when that is unset.
+func responseCacheFromEnv() (*responseCache, error) {
+	responseCacheOnce.Do(func() {
+		if path := os.Getenv("QUACK_CACHE"); path != "" {
+			responseCacheInst, responseCacheErr = newResponseCache(path)
+		}
+	})
+	return responseCacheInst, responseCacheErr
+}
+
+// defaultModeler is implemented by providers that know the model of requests without one.
+type defaultModeler interface {
+	DefaultModel() string
+}
+
+// cacheScope is the part of the cache keys of p that requests do not carry.
+func cacheScope(p Provider) string {
+	model := ""
+	if m, ok := p.(defaultModeler); ok {
+		model = m.DefaultModel()
+	}
+	return p.Name() + "\x00" + model
+}
+
+// cachedProvider answers requests from the response cache and caches the successful answers
+// of the provider it wraps. batch is set for the batch path.
+type cachedProvider struct {
+	Provider
+	batch BatchProvider
+	cache *responseCache
+	scope string
+}
+
+func (c *cachedProvider) Complete(ctx context.Context, req CompletionRequest) (Completion, error) {
+	key := responseCacheKey(c.scope, req)
+	if comp, ok := c.cache.get(key); ok {
+		comp.Cached = true
+		return comp, nil
+	}
+
+	comp, err := c.Provider.Complete(ctx, req)
+	if err == nil && comp.Text != "" {
+		c.cache.put(key, comp)
+	}
+	return comp, err
+}
+
+func (c *cachedProvider) RunBatch(
+	ctx context.Context,
+	reqs []CompletionRequest,
+	pollEvery time.Duration,
+	pollTimeout time.Duration,
+) (map[string]Completion, error) {
+	out := make(map[string]Completion, len(reqs))
+	keys := make(map[string]string, len(reqs))
+	missing := make([]CompletionRequest, 0, len(reqs))
+
+	for _, r := range reqs {
+		key := responseCacheKey(c.scope, r)
+		if comp, ok := c.cache.get(key); ok {
+			comp.Cached = true
+			out[r.CustomID] = comp
+			continue
+		}
+		keys[r.CustomID] = key
+		missing = append(missing, r)
+	}
+	if len(missing) == 0 {
+		return out, nil
+	}
+
+	res, err := c.batch.RunBatch(ctx, missing, pollEvery, pollTimeout)
+	for id, comp := range res {
+		if comp.Failure == "" && comp.Text != "" {
+			c.cache.put(keys[id], comp)
+		}
+		out[id] = comp
+	}
+	return out, err
+}
+
+// pairCache keeps the answers of (text, prompt) pairs in the response cache under the key of
+// the single request that would ask them, for the FusedDispatcher, which asks many at once.
+// A nil pairCache caches nothing.
+type pairCache struct {
+	cache  *responseCache
+	scope  string
+	params callParams
+}
+
+func (c *pairCache) key(text, prompt string) string {
+	req := CompletionRequest{Text: text, Prompt: prompt}
+	c.params.apply(&req)
+	return responseCacheKey(c.scope, req)
+}
+
+func (c *pairCache) get(text, prompt string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	comp, ok := c.cache.get(c.key(text, prompt))
+	return comp.Text, ok && comp.Text != ""
+}
+
+func (c *pairCache) put(text, prompt, answer string) {
+	if c != nil {
+		c.cache.put(c.key(text, prompt), Completion{Text: answer})
+	}
+}
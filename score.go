@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+)
+
+// scoreScale is the range of an ai_score answer.
+type scoreScale struct {
+	min, max float64
+	integer  bool // whole numbers, for the INTEGER overload
+}
+
+func (s scoreScale) check() error {
+	if s.min >= s.max {
+		return fmt.Errorf("min %v must be less than max %v", s.min, s.max)
+	}
+	return nil
+}
+
+// prompt is the instruction of ai_score for instruction on scale s.
+func (s scoreScale) prompt(instruction string) string {
+	kind := "a number"
+	if s.integer {
+		kind = "a whole number"
+	}
+	return fmt.Sprintf("%s\n\nAnswer with only %s from %s to %s.", instruction, kind, formatScore(s.min), formatScore(s.max))
+}
+
+// scoreAnswer matches an answer that is only a score: a number, maybe labeled, emphasized or
+// followed by its scale, as in "4", "**4**", "4/5", "Score: 4" or "4 out of 5."
+var scoreAnswer = regexp.MustCompile(`(?i)^[\s*_"'` + "`" + `]*(?:(?:score|rating)\s*[:=]\s*)?` +
+	`([-+]?(?:\d+(?:\.\d*)?|\.\d+))` +
+	`\s*(?:(?:/|out of)\s*[-+]?(?:\d+(?:\.\d*)?|\.\d+))?[\s*_"'.` + "`" + `]*$`)
+
+// scoreSlack is how far, as a share of the scale, a number may lie outside it and still be
+// clamped to it, for rounding such as 5.0000001 on 1 to 5.
+const scoreSlack = 0.01
+
+// parse reads the score of answer. Answers that are more than a score, like "On a scale of
+// 1 to 5, I'd give 4", and numbers outside the scale are errors, so they are asked again.
+// Whole-number scales round the number.
+func (s scoreScale) parse(answer string) (float64, error) {
+	m := scoreAnswer.FindStringSubmatch(answer)
+	if m == nil {
+		return 0, fmt.Errorf("%q is not only a number", answer)
+	}
+	f, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", m[1])
+	}
+	if s.integer {
+		f = math.Round(f)
+	}
+	slack := (s.max - s.min) * scoreSlack
+	if f < s.min-slack || f > s.max+slack {
+		return 0, fmt.Errorf("%s is outside %s to %s", formatScore(f), formatScore(s.min), formatScore(s.max))
+	}
+	return math.Max(s.min, math.Min(s.max, f)), nil
+}
+
+func formatScore(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	duckdb "github.com/duckdb/duckdb-go-bindings"
+	"github.com/mlafeldt/quack-go/duckdbext"
+)
+
+// quackaiSecret is an API key created with quackai_create_secret. It applies to providers of
+// its type whose base URL starts with scope; the longest matching scope wins.
+//
+// The DuckDB C API cannot register secret types for CREATE SECRET, hence the table functions.
+// Secrets live in memory and belong to the connection that created them, so tenants sharing a
+// server do not see each other's keys and nothing ends up in the process environment.
+type quackaiSecret struct {
+	name  string
+	typ   string
+	scope string
+	key   string
+}
+
+var (
+	secretsMu sync.Mutex
+	secrets   = map[uint64][]quackaiSecret{}
+)
+
+// secretTypes maps the secret types to their default base URL, the scope of unscoped secrets.
+var secretTypes = map[string]string{
+	"anthropic": "https://api.anthropic.com",
+	"openai":    "https://api.openai.com/v1",
+}
+
+// createSecret stores s for connection conn, replacing a secret of the same name.
+func createSecret(conn uint64, s quackaiSecret) error {
+	if _, ok := secretTypes[s.typ]; !ok {
+		types := make([]string, 0, len(secretTypes))
+		for t := range secretTypes {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+		return fmt.Errorf("unknown secret type %q, expected one of %s", s.typ, strings.Join(types, ", "))
+	}
+	if s.key == "" {
+		return errors.New("api_key must not be empty")
+	}
+
+	secretsMu.Lock()
+	list := secrets[conn]
+	for i := range list {
+		if list[i].name == s.name {
+			old := list[i].key
+			list[i] = s
+			secretsMu.Unlock()
+			forgetKeyUnlessUsed(old)
+			return nil
+		}
+	}
+	secrets[conn] = append(list, s)
+	secretsMu.Unlock()
+	return nil
+}
+
+// dropSecret removes the secret name of connection conn.
+func dropSecret(conn uint64, name string) error {
+	secretsMu.Lock()
+	list := secrets[conn]
+	for i := range list {
+		if list[i].name == name {
+			key := list[i].key
+			secrets[conn] = append(list[:i:i], list[i+1:]...)
+			secretsMu.Unlock()
+			forgetKeyUnlessUsed(key)
+			return nil
+		}
+	}
+	secretsMu.Unlock()
+	return fmt.Errorf("secret %q does not exist", name)
+}
+
+// forgetKeyUnlessUsed drops the providers, limiters and embedding dispatchers built for key
+// once no secret of any connection holds it, so a dropped key does not stay in memory.
+func forgetKeyUnlessUsed(key string) {
+	if secretKeyInUse(key) {
+		return
+	}
+	forgetAPIKey(key)
+	forgetEmbedKey(key)
+}
+
+func secretKeyInUse(key string) bool {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+
+	for _, list := range secrets {
+		for _, s := range list {
+			if s.key == key {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// secretKeyFor returns the key of the secret of connection conn that matches the configured
+// provider and its base URL, or "" to use the environment.
+func secretKeyFor(conn uint64) string {
+	return secretKeyForProvider(conn, os.Getenv("QUACK_LLM_PROVIDER"))
+}
+
+// secretKeyForProvider is secretKeyFor for the provider name, e.g. the embedding provider.
+func secretKeyForProvider(conn uint64, provider string) string {
+	typ, baseURL := providerEndpoint(provider)
+
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+
+	key, best := "", -1
+	for _, s := range secrets[conn] {
+		if s.typ == typ && scopeMatches(baseURL, s.scope) && len(s.scope) > best {
+			key, best = s.key, len(s.scope)
+		}
+	}
+	return key
+}
+
+// scopeMatches reports whether baseURL is scope or lies below it. Matches end at a path
+// boundary, so https://api.openai.com does not cover https://api.openai.com.evil.example.
+func scopeMatches(baseURL, scope string) bool {
+	return baseURL == scope || strings.HasPrefix(baseURL, scope+"/")
+}
+
+// providerEndpoint returns the secret type and base URL of the provider name, as in
+// QUACK_LLM_PROVIDER; providers without keys have no type.
+func providerEndpoint(provider string) (typ, baseURL string) {
+	switch provider {
+	case "", "anthropic":
+		typ, baseURL = "anthropic", os.Getenv("ANTHROPIC_BASE_URL")
+	case "openai":
+		typ, baseURL = "openai", os.Getenv("OPENAI_BASE_URL")
+	default:
+		return "", ""
+	}
+	if baseURL == "" {
+		baseURL = secretTypes[typ]
+	}
+	return typ, strings.TrimRight(baseURL, "/")
+}
+
+// quackaiCreateSecretFunction is CALL quackai_create_secret(type, api_key, name := ...,
+// scope := ...), the stand-in for CREATE SECRET (TYPE anthropic, API_KEY '...'). It returns
+// (name, type, scope).
+func quackaiCreateSecretFunction() duckdbext.TableFunction {
+	varchar := duckdbext.Primitive(duckdb.TypeVarchar)
+
+	return duckdbext.TableFunction{
+		Name:   "quackai_create_secret",
+		Params: []duckdbext.LogicalType{varchar, varchar},
+		NamedParams: map[string]duckdbext.LogicalType{
+			"name":  varchar,
+			"scope": varchar,
+		},
+		Bind: func(info duckdbext.TableBindInfo) (any, error) {
+			typ, err := info.StringParam(0)
+			if err != nil {
+				return nil, err
+			}
+			key, err := info.StringParam(1)
+			if err != nil {
+				return nil, err
+			}
+
+			s := quackaiSecret{typ: strings.ToLower(typ), key: key}
+			if s.name, err = info.NamedString("name", "__default_"+s.typ); err != nil {
+				return nil, err
+			}
+			if s.scope, err = info.NamedString("scope", secretTypes[s.typ]); err != nil {
+				return nil, err
+			}
+			s.scope = strings.TrimRight(s.scope, "/")
+
+			if err := createSecret(info.ConnectionID(), s); err != nil {
+				return nil, fmt.Errorf("quackai_create_secret: %w", err)
+			}
+
+			addVarcharColumns(info, "name", "type", "scope")
+			info.SetCardinality(1, true)
+			return &varcharRows{rows: [][]string{{s.name, s.typ, s.scope}}}, nil
+		},
+		Init: varcharRowsInit,
+		Func: varcharRowsFunc,
+	}
+}
+
+// quackaiDropSecretFunction is CALL quackai_drop_secret(name).
+func quackaiDropSecretFunction() duckdbext.TableFunction {
+	varchar := duckdbext.Primitive(duckdb.TypeVarchar)
+
+	return duckdbext.TableFunction{
+		Name:   "quackai_drop_secret",
+		Params: []duckdbext.LogicalType{varchar},
+		Bind: func(info duckdbext.TableBindInfo) (any, error) {
+			name, err := info.StringParam(0)
+			if err != nil {
+				return nil, err
+			}
+			if err := dropSecret(info.ConnectionID(), name); err != nil {
+				return nil, fmt.Errorf("quackai_drop_secret: %w", err)
+			}
+
+			addVarcharColumns(info, "name")
+			info.SetCardinality(1, true)
+			return &varcharRows{rows: [][]string{{name}}}, nil
+		},
+		Init: varcharRowsInit,
+		Func: varcharRowsFunc,
+	}
+}
+
+// quackaiSecretsFunction is quackai_secrets(): the secrets of the calling connection as
+// (name, type, scope); keys are never returned.
+func quackaiSecretsFunction() duckdbext.TableFunction {
+	return duckdbext.TableFunction{
+		Name: "quackai_secrets",
+		Bind: func(info duckdbext.TableBindInfo) (any, error) {
+			secretsMu.Lock()
+			rows := &varcharRows{}
+			for _, s := range secrets[info.ConnectionID()] {
+				rows.rows = append(rows.rows, []string{s.name, s.typ, s.scope})
+			}
+			secretsMu.Unlock()
+
+			addVarcharColumns(info, "name", "type", "scope")
+			info.SetCardinality(uint64(len(rows.rows)), true)
+			return rows, nil
+		},
+		Init: varcharRowsInit,
+		Func: varcharRowsFunc,
+	}
+}
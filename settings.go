@@ -0,0 +1,504 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	duckdb "github.com/duckdb/duckdb-go-bindings"
+	"github.com/mlafeldt/quack-go/duckdbext"
+)
+
+// sessionSettings are the options of one connection. They start from the environment and
+// are changed with CALL quackai_set(name, value); functions read them at bind time.
+//
+// The DuckDB C API cannot register options for SET, hence the table function. Entries are
+// kept until the extension is unloaded since there is no hook for closed connections.
+type sessionSettings struct {
+	// Strict raises a query error for failed rows instead of returning NULL.
+	Strict bool
+
+	// Dispatch selects the dispatchSet the functions of this session use.
+	Dispatch dispatchConfig
+}
+
+var (
+	sessionsMu sync.Mutex
+	sessions   = map[uint64]sessionSettings{}
+)
+
+// setting is one name quackai_set accepts.
+type setting struct {
+	name string
+	get  func(s *sessionSettings) string
+	set  func(s *sessionSettings, value string) error
+}
+
+// settingsTable lists the settings in the order quackai_settings returns them.
+var settingsTable = []setting{
+	{
+		name: "mode",
+		get:  func(s *sessionSettings) string { return s.Dispatch.Mode },
+		set: func(s *sessionSettings, value string) error {
+			switch m := strings.ToLower(value); m {
+			case "", "single", "fused", "batch":
+				s.Dispatch.Mode = m
+				return nil
+			}
+			return fmt.Errorf("%q is not single, fused or batch", value)
+		},
+	},
+	{
+		name: "model",
+		get:  func(s *sessionSettings) string { return s.Dispatch.Model },
+		set: func(s *sessionSettings, value string) error {
+			s.Dispatch.Model = strings.TrimSpace(value)
+			return nil
+		},
+	},
+	intSetting("max_tokens", 0, func(s *sessionSettings) *int { return &s.Dispatch.MaxTokens }),
+	{
+		name: "temperature",
+		get: func(s *sessionSettings) string {
+			if !s.Dispatch.HasTemperature {
+				return ""
+			}
+			return strconv.FormatFloat(s.Dispatch.Temperature, 'g', -1, 64)
+		},
+		set: func(s *sessionSettings, value string) error {
+			if strings.TrimSpace(value) == "" {
+				s.Dispatch.Temperature, s.Dispatch.HasTemperature = 0, false
+				return nil
+			}
+			return setOption(&s.Dispatch.callParams, "temperature", value)
+		},
+	},
+	{
+		name: "system",
+		get:  func(s *sessionSettings) string { return s.Dispatch.System },
+		set: func(s *sessionSettings, value string) error {
+			s.Dispatch.System = value
+			return nil
+		},
+	},
+	intSetting("rps", 0, func(s *sessionSettings) *int { return &s.Dispatch.RPS }),
+	intSetting("retries", 0, func(s *sessionSettings) *int { return &s.Dispatch.Retries }),
+	intSetting("retry_backoff_ms", 0, func(s *sessionSettings) *int { return &s.Dispatch.RetryBackoffMS }),
+	intSetting("fuse_delay_ms", 0, func(s *sessionSettings) *int { return &s.Dispatch.FuseDelayMS }),
+	intSetting("fuse_grace_ms", 0, func(s *sessionSettings) *int { return &s.Dispatch.FuseGraceMS }),
+	boolSetting("fused_multi", func(s *sessionSettings) *bool { return &s.Dispatch.FusedMulti }),
+	intSetting("fused_max_texts", 1, func(s *sessionSettings) *int { return &s.Dispatch.FusedMaxTexts }),
+	intSetting("fused_batch_ms", 0, func(s *sessionSettings) *int { return &s.Dispatch.FusedBatchMS }),
+	boolSetting("cache", func(s *sessionSettings) *bool { return &s.Dispatch.Cache }),
+	boolSetting("strict", func(s *sessionSettings) *bool { return &s.Strict }),
+}
+
+func intSetting(name string, minValue int, field func(s *sessionSettings) *int) setting {
+	return setting{
+		name: name,
+		get:  func(s *sessionSettings) string { return strconv.Itoa(*field(s)) },
+		set: func(s *sessionSettings, value string) error {
+			n, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil || n < minValue {
+				return fmt.Errorf("%q is not an integer >= %d", value, minValue)
+			}
+			*field(s) = n
+			return nil
+		},
+	}
+}
+
+func boolSetting(name string, field func(s *sessionSettings) *bool) setting {
+	return setting{
+		name: name,
+		get:  func(s *sessionSettings) string { return strconv.FormatBool(*field(s)) },
+		set: func(s *sessionSettings, value string) error {
+			b, err := strconv.ParseBool(strings.TrimSpace(value))
+			if err != nil {
+				return fmt.Errorf("%q is not a boolean", value)
+			}
+			*field(s) = b
+			return nil
+		},
+	}
+}
+
+func defaultSettings() sessionSettings {
+	var s sessionSettings
+	s.Strict, _ = strconv.ParseBool(strings.TrimSpace(os.Getenv("QUACK_LLM_STRICT")))
+	s.Dispatch = dispatchConfigFromEnv()
+	return s
+}
+
+// settingsFor returns the settings of connection conn.
+func settingsFor(conn uint64) sessionSettings {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	if s, ok := sessions[conn]; ok {
+		return s
+	}
+	return defaultSettings()
+}
+
+// setSetting changes one setting of connection conn and returns the normalized value. A
+// change of the dispatch configuration is only kept if its dispatchSet can be built, so
+// e.g. batch mode with a provider that lacks it fails here and not in the next query.
+func setSetting(conn uint64, name, value string) (string, error) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	s, ok := sessions[conn]
+	if !ok {
+		s = defaultSettings()
+	}
+
+	name = strings.ToLower(name)
+	i := slices.IndexFunc(settingsTable, func(st setting) bool { return st.name == name })
+	if i < 0 {
+		names := make([]string, len(settingsTable))
+		for j, st := range settingsTable {
+			names[j] = st.name
+		}
+		sort.Strings(names)
+		return "", fmt.Errorf("unknown setting %q, expected one of %s", name, strings.Join(names, ", "))
+	}
+
+	if err := settingsTable[i].set(&s, value); err != nil {
+		return "", fmt.Errorf("%s: %w", name, err)
+	}
+	if _, err := dispatchFor(sessionDispatch(conn, s)); err != nil {
+		return "", fmt.Errorf("%s: %w", name, err)
+	}
+
+	sessions[conn] = s
+	return settingsTable[i].get(&s), nil
+}
+
+// sessionDispatch is the dispatch configuration of connection conn: its settings plus the
+// key of its matching secret, looked up anew for every query.
+func sessionDispatch(conn uint64, s sessionSettings) dispatchConfig {
+	cfg := s.Dispatch
+	cfg.APIKey = secretKeyFor(conn)
+	return cfg
+}
+
+// querySettings is what settingsBind captures for one query: the session settings and the
+// dispatchSet they select.
+type querySettings struct {
+	sessionSettings
+	dispatch *dispatchSet
+	params   callParams // the call parameters after options, as dispatch applies them
+	prompt   string     // the library prompt of the prompt_ref option, "" without one
+}
+
+// promptColumn returns the prompt of each row: the library prompt of the query, or else
+// column col of input, where false means NULL.
+func (q querySettings) promptColumn(input duckdb.DataChunk, col int) func(row int) (string, bool) {
+	if q.prompt != "" {
+		return func(int) (string, bool) { return q.prompt, true }
+	}
+	v := duckdbext.ChunkVector(input, col)
+	return func(row int) (string, bool) {
+		if !v.Valid(row) {
+			return "", false
+		}
+		return v.String(row), true
+	}
+}
+
+// bindSettings resolves the settings of connection conn for a query. opts are the call
+// parameters of the query, see setOptions, and format what the function asks of answers,
+// zero for text.
+func bindSettings(conn uint64, opts map[string]any, format answerFormat) (querySettings, error) {
+	s := settingsFor(conn)
+
+	cfg := sessionDispatch(conn, s)
+	if err := setOptions(&cfg.callParams, opts); err != nil {
+		return querySettings{}, err
+	}
+	cfg.answerFormat = format
+
+	// a reference to the latest version is pinned, so the version is part of every key
+	var prompt string
+	if cfg.PromptRef != "" {
+		lib, err := promptLibraryFromEnv()
+		if err != nil {
+			return querySettings{}, err
+		}
+		p, err := lib.resolve(cfg.PromptRef)
+		if err != nil {
+			return querySettings{}, err
+		}
+		cfg.PromptRef, prompt = p.ref(), p.Prompt
+	}
+
+	ds, err := dispatchFor(cfg)
+	if err != nil {
+		return querySettings{}, err
+	}
+	return querySettings{sessionSettings: s, dispatch: ds, params: cfg.callParams, prompt: prompt}, nil
+}
+
+// settingsBind is the scalar bind of functions that honor session settings.
+func settingsBind(info duckdbext.ScalarBindInfo) (any, error) {
+	return bindSettings(info.ConnectionID(), nil, answerFormat{})
+}
+
+// optionsBind is settingsBind for overloads whose argument i is a constant options STRUCT
+// such as {'model': 'claude-3-5-haiku-latest', 'max_tokens': 1024, 'temperature': 0}.
+func optionsBind(i int) duckdbext.ScalarBindFunc {
+	return func(info duckdbext.ScalarBindInfo) (any, error) {
+		q, err := bindOptions(info, i, answerFormat{})
+		if err != nil {
+			return nil, err
+		}
+		if q.prompt != "" {
+			return nil, errors.New("prompt_ref replaces the prompt argument, call ai_llm(text, {'prompt_ref': '...'})")
+		}
+		return q, nil
+	}
+}
+
+// multiOptionsBind is optionsBind for ai_llm_multi, whose fused requests cannot carry
+// examples.
+func multiOptionsBind(info duckdbext.ScalarBindInfo) (any, error) {
+	q, err := optionsBind(2)(info)
+	if err != nil {
+		return nil, err
+	}
+	if q.(querySettings).params.Examples != nil {
+		return nil, errors.New("ai_llm_multi fuses its prompts and cannot take examples, use ai_llm per prompt")
+	}
+	return q, nil
+}
+
+// promptRefBind is optionsBind for overloads without a prompt argument, whose options name a
+// library prompt: ai_llm(text, {'prompt_ref': 'sentiment@2'}).
+func promptRefBind(i int) duckdbext.ScalarBindFunc {
+	return func(info duckdbext.ScalarBindInfo) (any, error) {
+		q, err := bindOptions(info, i, answerFormat{})
+		if err != nil {
+			return nil, err
+		}
+		if q.prompt == "" {
+			return nil, errors.New("without a prompt argument the options need a prompt_ref such as {'prompt_ref': 'sentiment@2'}")
+		}
+		return q, nil
+	}
+}
+
+// bindOptions is bindSettings with the options of constant argument i, or none for i < 0.
+func bindOptions(info duckdbext.ScalarBindInfo, i int, format answerFormat) (querySettings, error) {
+	if i < 0 {
+		return bindSettings(info.ConnectionID(), nil, format)
+	}
+
+	v, err := info.ConstantArgument(i)
+	if err != nil {
+		return querySettings{}, fmt.Errorf("options: %w", err)
+	}
+
+	var opts map[string]any
+	if v != nil {
+		var ok bool
+		if opts, ok = v.(map[string]any); !ok {
+			return querySettings{}, errors.New("options must be a STRUCT such as {'model': '...', 'max_tokens': 1024}")
+		}
+	}
+	return bindSettings(info.ConnectionID(), opts, format)
+}
+
+// setOptions overrides p with the call options model, max_tokens, temperature, system,
+// prompt_ref and examples. NULL options are ignored.
+func setOptions(p *callParams, opts map[string]any) error {
+	for name, v := range opts {
+		if v == nil {
+			continue
+		}
+		if err := setOption(p, name, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setOption(p *callParams, name string, v any) error {
+	switch name = strings.ToLower(name); name {
+	case "model":
+		s, ok := v.(string)
+		if !ok || s == "" {
+			return fmt.Errorf("model: %v is not a model name", v)
+		}
+		p.Model = s
+
+	case "max_tokens":
+		n, ok := optionNumber(v)
+		if !ok || n < 1 || n != float64(int(n)) {
+			return fmt.Errorf("max_tokens: %v is not a positive integer", v)
+		}
+		p.MaxTokens = int(n)
+
+	case "system":
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("system: %v is not text", v)
+		}
+		p.System = s
+
+	case "temperature":
+		t, ok := optionNumber(v)
+		if !ok || t < 0 {
+			return fmt.Errorf("temperature: %v is not a number >= 0", v)
+		}
+		p.Temperature, p.HasTemperature = t, true
+
+	case "prompt_ref":
+		s, ok := v.(string)
+		if !ok || s == "" {
+			return fmt.Errorf("prompt_ref: %v is not a prompt reference such as 'sentiment@2'", v)
+		}
+		p.PromptRef = s
+
+	case "examples":
+		s, ok := v.(string)
+		if !ok || s == "" {
+			return fmt.Errorf("examples: %v is not a table name or query", v)
+		}
+		examples, err := loadExamples(s)
+		if err != nil {
+			return err
+		}
+		p.Examples = internExamples(examples)
+
+	default:
+		return fmt.Errorf("unknown option %q, expected model, max_tokens, temperature, system, prompt_ref or examples", name)
+	}
+	return nil
+}
+
+// optionNumber reads numbers as ValueToGo returns them, or as text from quackai_set.
+func optionNumber(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// boundSettings returns the settings captured by settingsBind for this query.
+func boundSettings(info duckdb.FunctionInfo) querySettings {
+	if s, ok := duckdbext.ScalarBindData(info).(querySettings); ok {
+		return s
+	}
+	return querySettings{sessionSettings: defaultSettings(), dispatch: currentDispatch()}
+}
+
+// varcharRows is the bind data of the small quackai_* table functions: a few VARCHAR rows
+// computed at bind time and returned in one chunk.
+type varcharRows struct {
+	rows [][]string
+}
+
+type varcharRowsState struct {
+	done bool
+}
+
+// addVarcharColumns declares the result columns of a varcharRows function.
+func addVarcharColumns(info duckdbext.TableBindInfo, names ...string) {
+	for _, name := range names {
+		info.AddResultColumn(name, duckdbext.Primitive(duckdb.TypeVarchar))
+	}
+}
+
+// quackaiSetFunction is CALL quackai_set(name, value): changes a setting of the calling
+// connection and returns it as (name, value).
+func quackaiSetFunction() duckdbext.TableFunction {
+	varchar := duckdbext.Primitive(duckdb.TypeVarchar)
+
+	return duckdbext.TableFunction{
+		Name:   "quackai_set",
+		Params: []duckdbext.LogicalType{varchar, varchar},
+		Bind: func(info duckdbext.TableBindInfo) (any, error) {
+			name, err := info.StringParam(0)
+			if err != nil {
+				return nil, err
+			}
+			value, err := info.StringParam(1)
+			if err != nil {
+				return nil, err
+			}
+			if name == "" {
+				return nil, errors.New("quackai_set: name must not be empty")
+			}
+
+			value, err = setSetting(info.ConnectionID(), name, value)
+			if err != nil {
+				return nil, fmt.Errorf("quackai_set: %w", err)
+			}
+
+			addVarcharColumns(info, "name", "value")
+			info.SetCardinality(1, true)
+			return &varcharRows{rows: [][]string{{strings.ToLower(name), value}}}, nil
+		},
+		Init: varcharRowsInit,
+		Func: varcharRowsFunc,
+	}
+}
+
+// quackaiSettingsFunction is quackai_settings(): every setting of the calling connection as
+// (name, value).
+func quackaiSettingsFunction() duckdbext.TableFunction {
+	return duckdbext.TableFunction{
+		Name: "quackai_settings",
+		Bind: func(info duckdbext.TableBindInfo) (any, error) {
+			s := settingsFor(info.ConnectionID())
+
+			rows := &varcharRows{}
+			for _, st := range settingsTable {
+				rows.rows = append(rows.rows, []string{st.name, st.get(&s)})
+			}
+
+			addVarcharColumns(info, "name", "value")
+			info.SetCardinality(uint64(len(rows.rows)), true)
+			return rows, nil
+		},
+		Init: varcharRowsInit,
+		Func: varcharRowsFunc,
+	}
+}
+
+func varcharRowsInit(info duckdbext.TableInitInfo, bindData any) (any, error) {
+	return &varcharRowsState{}, nil
+}
+
+func varcharRowsFunc(scan duckdbext.TableScan, output duckdb.DataChunk) error {
+	b := scan.BindData.(*varcharRows)
+	s := scan.State.(*varcharRowsState)
+
+	if s.done {
+		duckdb.DataChunkSetSize(output, 0)
+		return nil
+	}
+	s.done = true
+
+	for row, values := range b.rows {
+		for col, v := range values {
+			duckdbext.ChunkVector(output, col).SetString(row, v)
+		}
+	}
+	duckdb.DataChunkSetSize(output, duckdb.IdxT(len(b.rows)))
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const summarySeparator = "\n---\n"
+
+// summarizeTexts summarizes texts with respect to prompt in map-reduce style: texts are packed
+// into parts of about maxChars characters, every part is summarized in one call, and the partial
+// summaries are combined the same way until a single summary is left. Every call holds a slot
+// of sem, which callers share to bound the requests in flight.
+func summarizeTexts(ctx context.Context, p Provider, sem chan struct{}, prompt string, texts []string, maxChars int) (string, error) {
+	if len(texts) == 0 {
+		return "", nil
+	}
+
+	instruction := fmt.Sprintf(
+		"The text contains several entries separated by lines of ---. Summarize all of them together, focusing on: %s",
+		prompt,
+	)
+
+	level := texts
+	for round := 0; ; round++ {
+		if round == 1 {
+			instruction = fmt.Sprintf(
+				"The text contains partial summaries separated by lines of ---. Combine them into one summary, focusing on: %s",
+				prompt,
+			)
+		}
+
+		parts := packTexts(level, maxChars)
+
+		summaries := make([]string, len(parts))
+		errs := make([]error, len(parts))
+
+		var wg sync.WaitGroup
+		for i, part := range parts {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+				c, err := p.Complete(ctx, CompletionRequest{Text: part, Prompt: instruction})
+				summaries[i], errs[i] = strings.TrimSpace(c.Text), err
+			}()
+		}
+		wg.Wait()
+
+		for _, err := range errs {
+			if err != nil {
+				return "", err
+			}
+		}
+
+		if len(summaries) == 1 {
+			return summaries[0], nil
+		}
+		level = summaries
+	}
+}
+
+// packTexts joins texts into parts of at most maxChars characters. A part always takes at least
+// two texts when available, so every round of summarizeTexts at least halves the input.
+func packTexts(texts []string, maxChars int) []string {
+	var (
+		parts []string
+		cur   []string
+		size  int
+	)
+
+	for _, t := range texts {
+		if len(cur) >= 2 && size+len(summarySeparator)+len(t) > maxChars {
+			parts = append(parts, strings.Join(cur, summarySeparator))
+			cur, size = nil, 0
+		}
+		if len(cur) > 0 {
+			size += len(summarySeparator)
+		}
+		cur = append(cur, t)
+		size += len(t)
+	}
+	if len(cur) > 0 {
+		parts = append(parts, strings.Join(cur, summarySeparator))
+	}
+
+	return parts
+}